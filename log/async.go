@@ -0,0 +1,172 @@
+package log
+
+import (
+	"sync"
+)
+
+// policies of an async logger when its queue is full
+const (
+	// wait for the queue
+	AsyncBlock = iota
+	// drop the log being written
+	AsyncDropNewest
+	// drop the oldest log in the queue
+	AsyncDropOldest
+)
+
+// asyncQueue passes records to the writer goroutine of an async logger.
+type asyncQueue struct {
+	// held to send, locked to stop
+	sync.RWMutex
+	queue  chan *Record
+	policy int
+	done   chan struct{}
+
+	// queued and processed, written or dropped, records so Flush can wait
+	cMutex    sync.Mutex
+	cond      *sync.Cond
+	queued    int64
+	processed int64
+	dropped   int64
+}
+
+// SetAsync makes l write logs in a background goroutine, so a slow handler doesn't
+// block the logging goroutines. At most size logs are queued, policy decides what to
+// do if the queue is full: AsyncBlock, AsyncDropNewest or AsyncDropOldest.
+//
+// Close writes the queued logs before closing the handler. Only the first call takes effect.
+func (l *Logger) SetAsync(size int, policy int) {
+	q := &l.async
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.queue != nil || l.closed.Get() == 1 {
+		return
+	}
+
+	if size <= 0 {
+		size = 1
+	}
+
+	q.queue = make(chan *Record, size)
+	q.policy = policy
+	q.done = make(chan struct{})
+	q.cond = sync.NewCond(&q.cMutex)
+
+	go l.runAsync(q.queue, q.done)
+}
+
+func (l *logger) runAsync(queue chan *Record, done chan struct{}) {
+	defer close(done)
+
+	for r := range queue {
+		l.handle(r)
+		l.async.process(false)
+	}
+}
+
+func (q *asyncQueue) process(dropped bool) {
+	q.cMutex.Lock()
+	q.processed++
+	if dropped {
+		q.dropped++
+	}
+	q.cond.Broadcast()
+	q.cMutex.Unlock()
+}
+
+// enqueue queues r for an async logger, it returns false if l is not async.
+func (l *logger) enqueue(r *Record) bool {
+	q := &l.async
+
+	q.RLock()
+	defer q.RUnlock()
+
+	if q.queue == nil {
+		return false
+	}
+
+	q.cMutex.Lock()
+	q.queued++
+	q.cMutex.Unlock()
+
+	switch q.policy {
+	case AsyncDropNewest:
+		select {
+		case q.queue <- r:
+		default:
+			q.process(true)
+		}
+	case AsyncDropOldest:
+		for {
+			select {
+			case q.queue <- r:
+				return true
+			default:
+			}
+
+			select {
+			case <-q.queue:
+				q.process(true)
+			default:
+			}
+		}
+	default:
+		q.queue <- r
+	}
+	return true
+}
+
+// Flush waits until the logs queued before are written, it returns at once if l is not async.
+func (l *Logger) Flush() {
+	q := &l.async
+
+	q.RLock()
+	async := q.queue != nil
+	q.RUnlock()
+
+	if !async {
+		return
+	}
+
+	q.cMutex.Lock()
+	for target := q.queued; q.processed < target; {
+		q.cond.Wait()
+	}
+	q.cMutex.Unlock()
+}
+
+// Dropped returns the number of logs dropped because the async queue was full.
+func (l *Logger) Dropped() int64 {
+	q := &l.async
+
+	q.cMutex.Lock()
+	defer q.cMutex.Unlock()
+	return q.dropped
+}
+
+// stopAsync writes the queued logs and stops the writer goroutine.
+func (l *logger) stopAsync() {
+	q := &l.async
+
+	q.Lock()
+	queue, done := q.queue, q.done
+	q.queue = nil
+	q.Unlock()
+
+	if queue != nil {
+		close(queue)
+		<-done
+	}
+}
+
+// SetAsync makes the std logger async, see Logger.SetAsync.
+func SetAsync(size int, policy int) {
+	std.SetAsync(size, policy)
+}
+
+// Flush waits until the queued logs of the std logger are written.
+func Flush() {
+	std.Flush()
+}
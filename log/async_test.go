@@ -0,0 +1,132 @@
+package log
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowHandler blocks writes until release is closed.
+type slowHandler struct {
+	sync.Mutex
+	release chan struct{}
+	lines   []string
+	closed  bool
+}
+
+func newSlowHandler() *slowHandler {
+	return &slowHandler{release: make(chan struct{})}
+}
+
+func (h *slowHandler) Write(p []byte) (int, error) {
+	<-h.release
+
+	h.Lock()
+	h.lines = append(h.lines, string(p))
+	h.Unlock()
+	return len(p), nil
+}
+
+func (h *slowHandler) Close() error {
+	h.Lock()
+	h.closed = true
+	h.Unlock()
+	return nil
+}
+
+func (h *slowHandler) count() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.lines)
+}
+
+func TestAsyncNotBlocking(t *testing.T) {
+	h := newSlowHandler()
+	l := New(h, 0)
+	l.SetAsync(16, AsyncBlock)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			l.Info(i)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked by handler")
+	}
+
+	close(h.release)
+	l.Flush()
+
+	if n := h.count(); n != 10 {
+		t.Fatal(n)
+	}
+	if n := l.Dropped(); n != 0 {
+		t.Fatal(n)
+	}
+
+	l.Close()
+	if !h.closed {
+		t.Fatal("handler not closed")
+	}
+}
+
+func TestAsyncDrop(t *testing.T) {
+	for _, policy := range []int{AsyncDropNewest, AsyncDropOldest} {
+		h := newSlowHandler()
+		l := New(h, 0)
+		l.SetAsync(4, policy)
+
+		for i := 0; i < 20; i++ {
+			l.Info(i)
+		}
+
+		close(h.release)
+		l.Flush()
+
+		// the writer may hold one log besides the queue
+		written := int64(h.count())
+		if written < 4 || written > 5 || written+l.Dropped() != 20 {
+			t.Fatal(policy, written, l.Dropped())
+		}
+
+		last := strings.TrimSpace(h.lines[len(h.lines)-1])
+		if policy == AsyncDropOldest && last != "19" {
+			t.Fatal(last)
+		} else if policy == AsyncDropNewest && last == "19" {
+			t.Fatal(last)
+		}
+
+		l.Close()
+	}
+}
+
+func TestAsyncCloseFlushes(t *testing.T) {
+	h := newSlowHandler()
+	l := New(h, 0)
+	l.SetAsync(100, AsyncBlock)
+
+	for i := 0; i < 50; i++ {
+		l.Info(i)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(h.release)
+	}()
+
+	l.Close()
+
+	if n := h.count(); n != 50 {
+		t.Fatal(n)
+	}
+
+	// logs after Close are discarded
+	l.Info("closed")
+	l.Flush()
+}
@@ -2,7 +2,7 @@
 //
 // It supports log different level: trace, debug, info, warn, error, fatal.
 //
-// It also supports different log handlers which you can log to stdout, file, socket, etc...
+// It also supports different log handlers which you can log to stdout, file, socket, syslog, etc...
 //
 // Use
 //
@@ -18,4 +18,8 @@
 //  l.Info("hello world")
 //  l.Infof("%s %d", "hello", 123)
 //
+//  //structured log with key value fields
+//  rl := l.With("request_id", id)
+//  rl.Infow("login", "user_id", uid)
+//
 package log
@@ -0,0 +1,197 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Formatter renders a record, the line break is added by the caller if missing.
+type Formatter interface {
+	Format(buf []byte, r *Record) []byte
+}
+
+// LevelName returns the name of the record level.
+func (r *Record) LevelName() string {
+	if r.Level >= 0 && r.Level < len(LevelName) {
+		return LevelName[r.Level]
+	}
+	return strconv.Itoa(r.Level)
+}
+
+// Caller returns "file.go:line" of the record, or empty if unknown.
+func (r *Record) Caller() string {
+	if len(r.File) == 0 {
+		return ""
+	}
+	return shortFile(r.File) + ":" + strconv.Itoa(r.Line)
+}
+
+// TextFormatter renders "[time] file:line [Level] msg key=value", parts are
+// selected by Flag of Ltime, Lfile and Llevel. It is the default of Logger.
+type TextFormatter struct {
+	Flag int
+}
+
+func (f TextFormatter) Format(buf []byte, r *Record) []byte {
+	if f.Flag&Ltime > 0 {
+		buf = append(buf, '[')
+		buf = r.Time.AppendFormat(buf, TimeFormat)
+		buf = append(buf, "] "...)
+	}
+
+	if f.Flag&Lfile > 0 {
+		if len(r.File) == 0 {
+			buf = append(buf, "???:0"...)
+		} else {
+			buf = append(buf, r.Caller()...)
+		}
+		buf = append(buf, ' ')
+	}
+
+	if f.Flag&Llevel > 0 {
+		buf = append(buf, '[')
+		buf = append(buf, r.LevelName()...)
+		buf = append(buf, "] "...)
+	}
+
+	buf = append(buf, strings.TrimSuffix(r.Message, "\n")...)
+	return appendFields(buf, r.Fields)
+}
+
+// JSONFormatter renders a JSON object per line with keys time, level, caller
+// and msg, followed by the fields in order.
+type JSONFormatter struct{}
+
+func (JSONFormatter) Format(buf []byte, r *Record) []byte {
+	buf = append(buf, `{"time":`...)
+	buf = appendJSON(buf, r.Time.Format(time.RFC3339Nano))
+	buf = append(buf, `,"level":`...)
+	buf = appendJSON(buf, strings.ToLower(r.LevelName()))
+	if len(r.File) > 0 {
+		buf = append(buf, `,"caller":`...)
+		buf = appendJSON(buf, r.Caller())
+	}
+	buf = append(buf, `,"msg":`...)
+	buf = appendJSON(buf, strings.TrimSuffix(r.Message, "\n"))
+
+	for _, f := range r.Fields {
+		buf = append(buf, ',')
+		buf = appendJSON(buf, f.Key)
+		buf = append(buf, ':')
+		buf = appendJSON(buf, f.Value)
+	}
+	return append(buf, '}')
+}
+
+// appendJSON appends v as JSON, an error is its message and a value
+// which can't be marshaled is its fmt string.
+func appendJSON(buf []byte, v interface{}) []byte {
+	if e, ok := v.(error); ok {
+		v = e.Error()
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return append(buf, data...)
+}
+
+// LogfmtFormatter renders "time=... level=info caller=file.go:12 msg=... key=value".
+type LogfmtFormatter struct{}
+
+func (LogfmtFormatter) Format(buf []byte, r *Record) []byte {
+	buf = append(buf, "time="...)
+	buf = r.Time.AppendFormat(buf, time.RFC3339Nano)
+
+	fields := make([]Field, 0, len(r.Fields)+3)
+	fields = append(fields, Field{"level", strings.ToLower(r.LevelName())})
+	if len(r.File) > 0 {
+		fields = append(fields, Field{"caller", r.Caller()})
+	}
+	fields = append(fields, Field{"msg", strings.TrimSuffix(r.Message, "\n")})
+	fields = append(fields, r.Fields...)
+
+	return appendFields(buf, fields)
+}
+
+// TemplateFormatter renders a record by a text/template, see NewTemplateFormatter.
+type TemplateFormatter struct {
+	t *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	// fields renders fields as " key=value"
+	"fields": func(fs []Field) string {
+		return string(appendFields(nil, fs))
+	},
+	"json": func(v interface{}) string {
+		return string(appendJSON(nil, v))
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// NewTemplateFormatter parses text executed with the *Record, e.g.
+//
+//	{{.Time.Format "15:04:05"}} {{.LevelName | upper}} {{.Message}}{{fields .Fields}}
+//
+// Functions fields, json, lower and upper can be used besides the builtin ones.
+func NewTemplateFormatter(text string) (*TemplateFormatter, error) {
+	t, err := template.New("log").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateFormatter{t}, nil
+}
+
+func (f *TemplateFormatter) Format(buf []byte, r *Record) []byte {
+	w := bytes.NewBuffer(buf)
+	if err := f.t.Execute(w, r); err != nil {
+		fmt.Fprintf(w, "log template error %v: %s", err, r.Message)
+	}
+	return w.Bytes()
+}
+
+// FormatHandler renders records by a Formatter and writes them to a Handler,
+// so every handler can have its own format.
+type FormatHandler struct {
+	sync.Mutex
+
+	h Handler
+	f Formatter
+
+	buf []byte
+}
+
+func NewFormatHandler(h Handler, f Formatter) *FormatHandler {
+	return &FormatHandler{h: h, f: f}
+}
+
+// Write writes p to the handler as is.
+func (h *FormatHandler) Write(p []byte) (int, error) {
+	return h.h.Write(p)
+}
+
+func (h *FormatHandler) Handle(r *Record) error {
+	h.Lock()
+	defer h.Unlock()
+
+	h.buf = h.f.Format(h.buf[:0], r)
+	if len(h.buf) == 0 || h.buf[len(h.buf)-1] != '\n' {
+		h.buf = append(h.buf, '\n')
+	}
+
+	_, err := h.h.Write(h.buf)
+	return err
+}
+
+func (h *FormatHandler) Close() error {
+	return h.h.Close()
+}
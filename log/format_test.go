@@ -0,0 +1,88 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecord() *Record {
+	return &Record{
+		Time:    time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC),
+		Level:   LevelWarn,
+		File:    "/src/app/main.go",
+		Line:    12,
+		Message: "hello world",
+		Fields:  []Field{{"request_id", 123}, {"err", errors.New("not found")}},
+	}
+}
+
+func TestFormatters(t *testing.T) {
+	r := testRecord()
+
+	tests := []struct {
+		f    Formatter
+		line string
+	}{
+		{TextFormatter{Ltime | Lfile | Llevel},
+			`[2015/01/02 03:04:05] main.go:12 [Warn] hello world request_id=123 err="not found"`},
+		{JSONFormatter{},
+			`{"time":"2015-01-02T03:04:05Z","level":"warn","caller":"main.go:12","msg":"hello world","request_id":123,"err":"not found"}`},
+		{LogfmtFormatter{},
+			`time=2015-01-02T03:04:05Z level=warn caller=main.go:12 msg="hello world" request_id=123 err="not found"`},
+	}
+
+	for _, test := range tests {
+		if line := string(test.f.Format(nil, r)); line != test.line {
+			t.Fatalf("%T: %s", test.f, line)
+		}
+	}
+
+	// a trailing newline of the message is not kept before the fields
+	r.Message += "\n"
+	for _, test := range tests {
+		if line := string(test.f.Format(nil, r)); line != test.line {
+			t.Fatalf("%T: %q", test.f, line)
+		}
+	}
+	r.Message = "hello world"
+
+	f, err := NewTemplateFormatter(`{{.Time.Format "15:04:05"}} {{.LevelName | upper}} {{.Caller}} {{.Message}}{{fields .Fields}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := string(f.Format(nil, r)); line != `03:04:05 WARN main.go:12 hello world request_id=123 err="not found"` {
+		t.Fatal(line)
+	}
+
+	if _, err = NewTemplateFormatter(`{{.Message`); err == nil {
+		t.Fatal("must error")
+	}
+}
+
+func TestFormatHandler(t *testing.T) {
+	var jsonBuf, textBuf bytes.Buffer
+
+	jh, _ := NewStreamHandler(&jsonBuf)
+	th, _ := NewStreamHandler(&textBuf)
+
+	NewDefault(NewFormatHandler(jh, JSONFormatter{})).With("user_id", "u1").Infow("login", "ok", true)
+	New(NewFormatHandler(th, LogfmtFormatter{}), 0).Errorf("failed %d", 1)
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(jsonBuf.Bytes(), &m); err != nil {
+		t.Fatal(jsonBuf.String(), err)
+	} else if m["msg"] != "login" || m["level"] != "info" || m["user_id"] != "u1" || m["ok"] != true {
+		t.Fatal(m)
+	} else if !strings.HasPrefix(m["caller"].(string), "format_test.go:") {
+		t.Fatal(m["caller"])
+	}
+
+	if line := textBuf.String(); !strings.Contains(line, " level=error caller=format_test.go:") ||
+		!strings.HasSuffix(line, ` msg="failed 1"`+"\n") {
+		t.Fatal(line)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -44,6 +43,14 @@ func (i *atomicInt32) Get() int {
 }
 
 type Logger struct {
+	*logger
+
+	// bound by With
+	fields []Field
+}
+
+// logger is shared by a Logger and its children created by With
+type logger struct {
 	level atomicInt32
 	flag  int
 
@@ -54,11 +61,14 @@ type Logger struct {
 	bufs     [][]byte
 
 	closed atomicInt32
+
+	// see SetAsync
+	async asyncQueue
 }
 
 //new a logger with specified handler and flag
 func New(handler Handler, flag int) *Logger {
-	var l = new(Logger)
+	var l = &Logger{logger: new(logger)}
 
 	l.level.Set(LevelInfo)
 	l.handler = handler
@@ -84,7 +94,7 @@ func newStdHandler() *StreamHandler {
 
 var std = NewDefault(newStdHandler())
 
-func (l *Logger) popBuf() []byte {
+func (l *logger) popBuf() []byte {
 	l.bufMutex.Lock()
 	var buf []byte
 	if len(l.bufs) == 0 {
@@ -98,7 +108,7 @@ func (l *Logger) popBuf() []byte {
 	return buf
 }
 
-func (l *Logger) putBuf(buf []byte) {
+func (l *logger) putBuf(buf []byte) {
 	l.bufMutex.Lock()
 	if len(l.bufs) < maxBufPoolSize {
 		buf = buf[0:0]
@@ -107,12 +117,15 @@ func (l *Logger) putBuf(buf []byte) {
 	l.bufMutex.Unlock()
 }
 
+// Close writes the queued logs of an async logger and closes the handler.
 func (l *Logger) Close() {
 	if l.closed.Get() == 1 {
 		return
 	}
 	l.closed.Set(1)
 
+	l.stopAsync()
+
 	l.handler.Close()
 }
 
@@ -154,6 +167,23 @@ func (l *Logger) SetHandler(h Handler) {
 }
 
 func (l *Logger) Output(callDepth int, level int, format string, v ...interface{}) {
+	if l.closed.Get() == 1 || l.level.Get() > level {
+		return
+	}
+
+	var s string
+	if format == "" {
+		s = fmt.Sprint(v...)
+	} else {
+		s = fmt.Sprintf(format, v...)
+	}
+
+	l.output(callDepth+1, level, s, nil)
+}
+
+// output logs msg with the bound fields and fields, a RecordHandler receives the
+// record, other handlers receive the rendered line.
+func (l *Logger) output(callDepth int, level int, msg string, fields []Field) {
 	if l.closed.Get() == 1 {
 		// closed
 		return
@@ -164,63 +194,58 @@ func (l *Logger) Output(callDepth int, level int, format string, v ...interface{
 		return
 	}
 
-	var s string
-	if format == "" {
-		s = fmt.Sprint(v...)
-	} else {
-		s = fmt.Sprintf(format, v...)
+	if len(l.fields) > 0 {
+		fields = append(append(make([]Field, 0, len(l.fields)+len(fields)), l.fields...), fields...)
 	}
 
-	buf := l.popBuf()
+	l.hMutex.Lock()
+	_, ok := l.handler.(RecordHandler)
+	l.hMutex.Unlock()
 
-	if l.flag&Ltime > 0 {
-		now := time.Now().Format(TimeFormat)
-		buf = append(buf, '[')
-		buf = append(buf, now...)
-		buf = append(buf, "] "...)
-	}
+	r := &Record{Time: time.Now(), Level: level, Message: msg, Fields: fields}
 
-	if l.flag&Lfile > 0 {
-		_, file, line, ok := runtime.Caller(callDepth)
-		if !ok {
-			file = "???"
-			line = 0
-		} else {
-			for i := len(file) - 1; i > 0; i-- {
-				if file[i] == '/' {
-					file = file[i+1:]
-					break
-				}
-			}
+	if ok || l.flag&Lfile > 0 {
+		if _, file, line, ok := runtime.Caller(callDepth); ok {
+			r.File, r.Line = file, line
 		}
+	}
 
-		buf = append(buf, file...)
-		buf = append(buf, ':')
-
-		buf = strconv.AppendInt(buf, int64(line), 10)
-		buf = append(buf, ' ')
+	if !l.enqueue(r) {
+		l.handle(r)
 	}
+}
+
+// handle passes r to a RecordHandler, or writes the rendered line to other handlers.
+func (l *logger) handle(r *Record) {
+	l.hMutex.Lock()
+	defer l.hMutex.Unlock()
 
-	if l.flag&Llevel > 0 {
-		buf = append(buf, '[')
-		buf = append(buf, LevelName[level]...)
-		buf = append(buf, "] "...)
+	if rh, ok := l.handler.(RecordHandler); ok {
+		rh.Handle(r)
+		return
 	}
 
-	buf = append(buf, s...)
+	buf := l.popBuf()
+
+	buf = TextFormatter{Flag: l.flag}.Format(buf, r)
 
-	if s[len(s)-1] != '\n' {
+	if len(buf) == 0 || buf[len(buf)-1] != '\n' {
 		buf = append(buf, '\n')
 	}
 
-	// l.msg <- buf
-
-	l.hMutex.Lock()
 	l.handler.Write(buf)
-	l.hMutex.Unlock()
 	l.putBuf(buf)
 }
 
+func shortFile(file string) string {
+	for i := len(file) - 1; i > 0; i-- {
+		if file[i] == '/' {
+			return file[i+1:]
+		}
+	}
+	return file
+}
+
 //log with Trace level
 func (l *Logger) Trace(v ...interface{}) {
 	l.Output(2, LevelTrace, "", v...)
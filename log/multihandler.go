@@ -0,0 +1,100 @@
+package log
+
+import (
+	"sync"
+)
+
+type multiOutput struct {
+	h     Handler
+	level int
+	f     Formatter
+}
+
+// MultiHandler writes every record to several handlers, each one with its own minimum
+// level and Formatter, e.g. all logs go to a RotatingFileHandler while Error and above
+// also go to a SocketHandler.
+//
+// The logger level still filters first, so set it no higher than the lowest handler level.
+type MultiHandler struct {
+	sync.Mutex
+
+	outs []multiOutput
+
+	buf []byte
+}
+
+func NewMultiHandler() *MultiHandler {
+	return new(MultiHandler)
+}
+
+// AddHandler adds h which gets the records at level and above. The records are rendered
+// by f, if f is nil, a RecordHandler gets the records and other handlers get the
+// TextFormatter output with Ltime|Lfile|Llevel.
+func (h *MultiHandler) AddHandler(handler Handler, level int, f Formatter) {
+	h.Lock()
+	h.outs = append(h.outs, multiOutput{h: handler, level: level, f: f})
+	h.Unlock()
+}
+
+// Write writes p to all handlers as is, without level filtering.
+func (h *MultiHandler) Write(p []byte) (int, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	var err error
+	for _, o := range h.outs {
+		if _, e := o.h.Write(p); e != nil && err == nil {
+			err = e
+		}
+	}
+	return len(p), err
+}
+
+// Handle writes r to the handlers whose level it reaches, it returns the first error
+// but still writes to the other handlers.
+func (h *MultiHandler) Handle(r *Record) error {
+	h.Lock()
+	defer h.Unlock()
+
+	var err error
+	for _, o := range h.outs {
+		if r.Level < o.level {
+			continue
+		}
+
+		var e error
+		if rh, ok := o.h.(RecordHandler); ok && o.f == nil {
+			e = rh.Handle(r)
+		} else {
+			f := o.f
+			if f == nil {
+				f = TextFormatter{Flag: Ltime | Lfile | Llevel}
+			}
+
+			h.buf = f.Format(h.buf[:0], r)
+			if len(h.buf) == 0 || h.buf[len(h.buf)-1] != '\n' {
+				h.buf = append(h.buf, '\n')
+			}
+			_, e = o.h.Write(h.buf)
+		}
+
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Close closes all handlers.
+func (h *MultiHandler) Close() error {
+	h.Lock()
+	defer h.Unlock()
+
+	var err error
+	for _, o := range h.outs {
+		if e := o.h.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
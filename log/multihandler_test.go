@@ -0,0 +1,60 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type errHandler struct{}
+
+func (h errHandler) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func (h errHandler) Close() error {
+	return nil
+}
+
+func TestMultiHandler(t *testing.T) {
+	var all, errs bytes.Buffer
+	allH, _ := NewStreamHandler(&all)
+	errH, _ := NewStreamHandler(&errs)
+	rh := new(testRecordHandler)
+
+	h := NewMultiHandler()
+	h.AddHandler(allH, LevelDebug, LogfmtFormatter{})
+	h.AddHandler(errH, LevelError, nil)
+	h.AddHandler(rh, LevelWarn, nil)
+
+	l := NewDefault(h)
+	l.SetLevel(LevelTrace)
+
+	l.Trace("trace")
+	l.Infow("started", "port", 80)
+	l.Warn("slow")
+	l.Error("failed")
+
+	if n := strings.Count(all.String(), "\n"); n != 3 || !strings.Contains(all.String(), `msg=started port=80`) {
+		t.Fatal(all.String())
+	}
+
+	if line := errs.String(); strings.Count(line, "\n") != 1 || !strings.Contains(line, "multihandler_test.go:") ||
+		!strings.HasSuffix(line, "[Error] failed\n") {
+		t.Fatal(line)
+	}
+
+	if len(rh.records) != 2 || rh.records[0].Message != "slow" || rh.records[1].Message != "failed" {
+		t.Fatal(rh.records)
+	}
+
+	// a failed handler doesn't stop the others
+	h.AddHandler(errHandler{}, LevelTrace, nil)
+	if err := h.Handle(&Record{Level: LevelError, Message: "again"}); err == nil {
+		t.Fatal("must error")
+	}
+	if len(rh.records) != 3 {
+		t.Fatal(rh.records)
+	}
+}
@@ -0,0 +1,152 @@
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field is a key value pair of a structured log.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Record is a log passed to a RecordHandler.
+type Record struct {
+	Time  time.Time
+	Level int
+
+	// caller
+	File string
+	Line int
+
+	Message string
+	Fields  []Field
+}
+
+// RecordHandler receives records instead of rendered lines, so it can keep the fields.
+type RecordHandler interface {
+	Handler
+	Handle(r *Record) error
+}
+
+// badKey is the key of a value without key
+const badKey = "!BADKEY"
+
+// fields converts alternating keys and values to fields, a Field is used as is.
+func fields(kv []interface{}) []Field {
+	fs := make([]Field, 0, len(kv)/2)
+	for i := 0; i < len(kv); i++ {
+		if f, ok := kv[i].(Field); ok {
+			fs = append(fs, f)
+			continue
+		}
+
+		if i == len(kv)-1 {
+			fs = append(fs, Field{badKey, kv[i]})
+			break
+		}
+
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		fs = append(fs, Field{key, kv[i+1]})
+		i++
+	}
+	return fs
+}
+
+// appendFields appends fields as " key=value", a value with space, quote or '=' is quoted.
+func appendFields(buf []byte, fields []Field) []byte {
+	for _, f := range fields {
+		buf = append(buf, ' ')
+		buf = append(buf, f.Key...)
+		buf = append(buf, '=')
+
+		s := fieldString(f.Value)
+		if len(s) == 0 || strings.ContainsAny(s, " \t\r\n\"=") {
+			buf = strconv.AppendQuote(buf, s)
+		} else {
+			buf = append(buf, s...)
+		}
+	}
+	return buf
+}
+
+func fieldString(v interface{}) string {
+	if e, ok := v.(error); ok {
+		return e.Error()
+	}
+	return fmt.Sprint(v)
+}
+
+// With returns a child logger which logs with the fields of keys and values
+// besides the fields of l. It shares the handler and level with l.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fs := make([]Field, 0, len(l.fields)+len(kv)/2)
+	fs = append(fs, l.fields...)
+	fs = append(fs, fields(kv)...)
+	return &Logger{logger: l.logger, fields: fs}
+}
+
+// log with Trace level and alternating keys and values
+func (l *Logger) Tracew(msg string, kv ...interface{}) {
+	l.output(2, LevelTrace, msg, fields(kv))
+}
+
+// log with Debug level and alternating keys and values
+func (l *Logger) Debugw(msg string, kv ...interface{}) {
+	l.output(2, LevelDebug, msg, fields(kv))
+}
+
+// log with info level and alternating keys and values
+func (l *Logger) Infow(msg string, kv ...interface{}) {
+	l.output(2, LevelInfo, msg, fields(kv))
+}
+
+// log with warn level and alternating keys and values
+func (l *Logger) Warnw(msg string, kv ...interface{}) {
+	l.output(2, LevelWarn, msg, fields(kv))
+}
+
+// log with error level and alternating keys and values
+func (l *Logger) Errorw(msg string, kv ...interface{}) {
+	l.output(2, LevelError, msg, fields(kv))
+}
+
+// log with fatal level and alternating keys and values
+func (l *Logger) Fatalw(msg string, kv ...interface{}) {
+	l.output(2, LevelFatal, msg, fields(kv))
+}
+
+// With returns a child logger of the std logger, see Logger.With.
+func With(kv ...interface{}) *Logger {
+	return std.With(kv...)
+}
+
+func Tracew(msg string, kv ...interface{}) {
+	std.output(2, LevelTrace, msg, fields(kv))
+}
+
+func Debugw(msg string, kv ...interface{}) {
+	std.output(2, LevelDebug, msg, fields(kv))
+}
+
+func Infow(msg string, kv ...interface{}) {
+	std.output(2, LevelInfo, msg, fields(kv))
+}
+
+func Warnw(msg string, kv ...interface{}) {
+	std.output(2, LevelWarn, msg, fields(kv))
+}
+
+func Errorw(msg string, kv ...interface{}) {
+	std.output(2, LevelError, msg, fields(kv))
+}
+
+func Fatalw(msg string, kv ...interface{}) {
+	std.output(2, LevelFatal, msg, fields(kv))
+}
@@ -0,0 +1,81 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+type testRecordHandler struct {
+	records []*Record
+}
+
+func (h *testRecordHandler) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (h *testRecordHandler) Close() error {
+	return nil
+}
+
+func (h *testRecordHandler) Handle(r *Record) error {
+	h.records = append(h.records, r)
+	return nil
+}
+
+func TestRecordHandler(t *testing.T) {
+	h := new(testRecordHandler)
+	l := NewDefault(h)
+
+	rl := l.With("request_id", 123)
+	rl.With("user_id", "u1").Infow("login", "ok", true)
+	rl.Debugw("filtered")
+	l.Infof("%s %d", "hello", 1)
+
+	if len(h.records) != 2 {
+		t.Fatal(len(h.records))
+	}
+
+	r := h.records[0]
+	if r.Level != LevelInfo || r.Message != "login" || filepath.Base(r.File) != "record_test.go" || r.Line == 0 {
+		t.Fatal(r)
+	}
+	want := []Field{{"request_id", 123}, {"user_id", "u1"}, {"ok", true}}
+	if len(r.Fields) != len(want) {
+		t.Fatal(r.Fields)
+	}
+	for i, f := range want {
+		if r.Fields[i] != f {
+			t.Fatal(r.Fields)
+		}
+	}
+
+	if r = h.records[1]; r.Message != "hello 1" || len(r.Fields) != 0 {
+		t.Fatal(r)
+	}
+
+	// bound fields of the parent are not changed
+	if len(l.fields) != 0 || len(rl.fields) != 1 {
+		t.Fatal(l.fields, rl.fields)
+	}
+}
+
+func TestFieldsText(t *testing.T) {
+	var buf bytes.Buffer
+	h, _ := NewStreamHandler(&buf)
+	l := New(h, Llevel)
+
+	l.With("id", 1).Warnw("failed", "err", errors.New("not found"), "empty", "", Field{"f", 1.5}, "odd")
+
+	want := `[Warn] failed id=1 err="not found" empty="" f=1.5 !BADKEY=odd` + "\n"
+	if buf.String() != want {
+		t.Fatal(buf.String())
+	}
+
+	buf.Reset()
+	l.Info("")
+	if buf.String() != "[Info] \n" {
+		t.Fatal(buf.String())
+	}
+}
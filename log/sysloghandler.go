@@ -0,0 +1,351 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog facilities
+const (
+	FacilityKern = iota
+	FacilityUser
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLpr
+	FacilityNews
+	FacilityUucp
+	FacilityCron
+	FacilityAuthPriv
+	FacilityFtp
+)
+
+const (
+	FacilityLocal0 = iota + 16
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+// syslog severities
+const (
+	SeverityEmerg = iota
+	SeverityAlert
+	SeverityCrit
+	SeverityErr
+	SeverityWarning
+	SeverityNotice
+	SeverityInfo
+	SeverityDebug
+)
+
+// syslog message formats
+const (
+	SyslogRFC5424 = iota
+	SyslogRFC3164
+)
+
+// DefaultSyslogSDID is the SD-ID of the structured data element holding the record fields,
+// 32473 is the private enterprise number reserved for documentation.
+const DefaultSyslogSDID = "fields@32473"
+
+var levelSeverity = [6]int{SeverityDebug, SeverityDebug, SeverityInfo, SeverityWarning, SeverityErr, SeverityCrit}
+
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+var errNoSyslog = errors.New("no local syslog socket")
+
+// Severity returns the syslog severity of a log level.
+func Severity(level int) int {
+	if level < 0 {
+		return SeverityDebug
+	} else if level >= len(levelSeverity) {
+		return SeverityCrit
+	}
+	return levelSeverity[level]
+}
+
+// SyslogHandler writes logs to a syslog daemon, like rsyslog, through a unix socket, udp or tcp.
+// The record fields are sent as RFC 5424 structured data, or appended to the message as key=value for RFC 3164.
+type SyslogHandler struct {
+	sync.Mutex
+
+	network  string
+	addr     string
+	facility int
+	tag      string
+	hostname string
+	sdID     string
+	format   int
+	local    bool
+
+	c      net.Conn
+	stream bool
+	buf    []byte
+}
+
+// NewSyslogHandler creates a handler writing to addr over network, "unix", "unixgram", "udp" or "tcp".
+// If network and addr are both empty, it writes to the local syslog socket in RFC 3164 format,
+// otherwise in RFC 5424 format. The tag is the app name, os.Args[0] if empty.
+// Like SocketHandler, it connects at the first write and reconnects after errors.
+func NewSyslogHandler(network string, addr string, facility int, tag string) (*SyslogHandler, error) {
+	if facility < FacilityKern || facility > FacilityLocal7 {
+		return nil, fmt.Errorf("invalid syslog facility %d", facility)
+	}
+
+	h := new(SyslogHandler)
+
+	h.network = network
+	h.addr = addr
+	h.facility = facility
+	h.tag = tag
+	if len(h.tag) == 0 {
+		h.tag = filepath.Base(os.Args[0])
+	}
+	h.hostname, _ = os.Hostname()
+	h.sdID = DefaultSyslogSDID
+
+	h.local = len(network) == 0 && len(addr) == 0
+	if h.local {
+		h.format = SyslogRFC3164
+	}
+
+	return h, nil
+}
+
+// SetFormat sets the message format, SyslogRFC5424 or SyslogRFC3164.
+func (h *SyslogHandler) SetFormat(format int) {
+	h.Lock()
+	h.format = format
+	h.Unlock()
+}
+
+// SetHostname sets the hostname in the messages, os.Hostname() by default.
+func (h *SyslogHandler) SetHostname(hostname string) {
+	h.Lock()
+	h.hostname = hostname
+	h.Unlock()
+}
+
+// SetStructuredDataID sets the SD-ID of the RFC 5424 structured data, DefaultSyslogSDID by default.
+func (h *SyslogHandler) SetStructuredDataID(id string) {
+	h.Lock()
+	h.sdID = id
+	h.Unlock()
+}
+
+// Write sends p as a message with the Info severity.
+func (h *SyslogHandler) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	if err := h.Handle(&Record{Time: time.Now(), Level: LevelInfo, Message: msg}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Handle sends r with the severity of its level, it reconnects once if sending fails.
+func (h *SyslogHandler) Handle(r *Record) error {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.format == SyslogRFC3164 {
+		h.buf = h.format3164(h.buf[:0], r)
+	} else {
+		h.buf = h.format5424(h.buf[:0], r)
+	}
+
+	var err error
+	for i := 0; i < 2; i++ {
+		if err = h.connect(); err != nil {
+			continue
+		}
+
+		if err = h.send(h.buf); err == nil {
+			return nil
+		}
+
+		h.c.Close()
+		h.c = nil
+	}
+	return err
+}
+
+func (h *SyslogHandler) Close() error {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.c != nil {
+		h.c.Close()
+		h.c = nil
+	}
+	return nil
+}
+
+// send frames msg by octet counting of RFC 6587 for RFC 5424 over a stream, or by a newline.
+func (h *SyslogHandler) send(msg []byte) error {
+	var err error
+	switch {
+	case !h.stream:
+		_, err = h.c.Write(msg)
+	case h.format == SyslogRFC5424 && !h.local:
+		_, err = h.c.Write(append(strconv.AppendInt(nil, int64(len(msg)), 10), ' '))
+		if err == nil {
+			_, err = h.c.Write(msg)
+		}
+	default:
+		_, err = h.c.Write(append(msg, '\n'))
+	}
+	return err
+}
+
+func (h *SyslogHandler) connect() error {
+	if h.c != nil {
+		return nil
+	}
+
+	if !h.local {
+		return h.dial(h.network, h.addr)
+	}
+
+	for _, path := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if h.dial(network, path) == nil {
+				return nil
+			}
+		}
+	}
+	return errNoSyslog
+}
+
+func (h *SyslogHandler) dial(network string, addr string) error {
+	c, err := net.DialTimeout(network, addr, 20*time.Second)
+	if err != nil {
+		return err
+	}
+
+	h.c = c
+	h.stream = network != "unixgram" && !strings.HasPrefix(network, "udp")
+	return nil
+}
+
+func (h *SyslogHandler) priority(r *Record) int {
+	return h.facility<<3 | Severity(r.Level)
+}
+
+// format5424 renders "<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG".
+func (h *SyslogHandler) format5424(buf []byte, r *Record) []byte {
+	buf = append(buf, '<')
+	buf = strconv.AppendInt(buf, int64(h.priority(r)), 10)
+	buf = append(buf, ">1 "...)
+	buf = r.Time.AppendFormat(buf, "2006-01-02T15:04:05.000000Z07:00")
+	buf = append(buf, ' ')
+	buf = appendHeaderField(buf, h.hostname, 255)
+	buf = append(buf, ' ')
+	buf = appendHeaderField(buf, h.tag, 48)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(os.Getpid()), 10)
+	buf = append(buf, " - "...)
+
+	if len(r.Fields) == 0 {
+		buf = append(buf, '-')
+	} else {
+		buf = append(buf, '[')
+		buf = append(buf, h.sdID...)
+		for _, f := range r.Fields {
+			buf = append(buf, ' ')
+			buf = appendHeaderField(buf, f.Key, 32)
+			buf = append(buf, `="`...)
+			buf = appendParamValue(buf, fieldString(f.Value))
+			buf = append(buf, '"')
+		}
+		buf = append(buf, ']')
+	}
+
+	if msg := strings.TrimRight(r.Message, "\n"); len(msg) > 0 {
+		buf = append(buf, ' ')
+		buf = appendMessage(buf, msg)
+	}
+	return buf
+}
+
+// format3164 renders "<PRI>TIMESTAMP HOSTNAME TAG[PID]: MSG key=value",
+// the hostname is omitted for the local socket.
+func (h *SyslogHandler) format3164(buf []byte, r *Record) []byte {
+	buf = append(buf, '<')
+	buf = strconv.AppendInt(buf, int64(h.priority(r)), 10)
+	buf = append(buf, '>')
+	buf = r.Time.AppendFormat(buf, time.Stamp)
+	buf = append(buf, ' ')
+	if !h.local {
+		buf = appendHeaderField(buf, h.hostname, 255)
+		buf = append(buf, ' ')
+	}
+	buf = append(buf, h.tag...)
+	buf = append(buf, '[')
+	buf = strconv.AppendInt(buf, int64(os.Getpid()), 10)
+	buf = append(buf, "]: "...)
+	buf = appendMessage(buf, strings.TrimRight(r.Message, "\n"))
+	buf = appendFields(buf, r.Fields)
+	return buf
+}
+
+// appendHeaderField appends s limited to n printable ascii chars, "-" if s is empty,
+// space, '=', ']' and '"' are replaced by '_' so s can also be a SD param name.
+func appendHeaderField(buf []byte, s string, n int) []byte {
+	if len(s) == 0 {
+		return append(buf, '-')
+	}
+
+	if len(s) > n {
+		s = s[:n]
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			c = '_'
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
+
+// appendMessage appends msg escaping '\n' and '\r' as `\n` and `\r`,
+// so a message is one line for the newline framing over a stream.
+func appendMessage(buf []byte, msg string) []byte {
+	for i := 0; i < len(msg); i++ {
+		switch c := msg[i]; c {
+		case '\n':
+			buf = append(buf, `\n`...)
+		case '\r':
+			buf = append(buf, `\r`...)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return buf
+}
+
+// appendParamValue appends s escaping '"', '\' and ']'.
+func appendParamValue(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\\', ']':
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, s[i])
+	}
+	return buf
+}
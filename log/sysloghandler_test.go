@@ -0,0 +1,183 @@
+package log
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readPacket(t *testing.T, c net.PacketConn) string {
+	buf := make([]byte, 4096)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := c.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestSyslogUDP(t *testing.T) {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	h, err := NewSyslogHandler("udp", c.LocalAddr().String(), FacilityLocal3, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetHostname("host1")
+
+	l := NewDefault(h)
+	defer l.Close()
+
+	l.With("request_id", 12).Errorw("login failed", "user", `a"b]`)
+	l.Info("started")
+
+	re := regexp.MustCompile(`^<155>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}(Z|[+-]\d\d:\d\d) host1 app \d+ - ` +
+		`\[fields@32473 request_id="12" user="a\\"b\\]"\] login failed$`)
+	if msg := readPacket(t, c); !re.MatchString(msg) {
+		t.Fatal(msg)
+	}
+
+	if msg := readPacket(t, c); msg[:7] != "<158>1 " || !strings.HasSuffix(msg, " app "+strconv.Itoa(os.Getpid())+" - - started") {
+		t.Fatal(msg)
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	h, err := NewSyslogHandler("tcp", ln.Addr().String(), FacilityDaemon, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetHostname("host1")
+
+	h.Handle(&Record{Time: time.Now(), Level: LevelWarn, Message: "disk full"})
+
+	h.SetFormat(SyslogRFC3164)
+	h.Handle(&Record{Time: time.Date(2015, 1, 2, 3, 4, 5, 0, time.Local), Level: LevelFatal,
+		Message: "exit", Fields: []Field{{"code", 1}}})
+
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(c)
+
+	// octet counting framing for RFC 5424
+	n, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, _ := strconv.Atoi(strings.TrimSpace(n))
+	msg := make([]byte, size)
+	if _, err = io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	} else if s := string(msg); s[:6] != "<28>1 " || !strings.HasSuffix(s, " - - disk full") {
+		t.Fatal(s)
+	}
+
+	// newline framing for RFC 3164
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<26>Jan  2 03:04:05 host1 app[" + strconv.Itoa(os.Getpid()) + "]: exit code=1\n"; line != want {
+		t.Fatal(line)
+	}
+}
+
+func TestSyslogNewline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	h, err := NewSyslogHandler("tcp", ln.Addr().String(), FacilityUser, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetFormat(SyslogRFC3164)
+
+	// the trailing newline is trimmed and the others don't split the frame
+	h.Handle(&Record{Time: time.Now(), Level: LevelInfo, Message: "a\nb\r\n"})
+	h.Handle(&Record{Time: time.Now(), Level: LevelInfo, Message: "c\n"})
+
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(c)
+
+	for _, want := range []string{`]: a\nb\r` + "\n", "]: c\n"} {
+		if line, err := r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		} else if !strings.HasSuffix(line, want) {
+			t.Fatal(line)
+		}
+	}
+
+	if msg := string(h.format5424(nil, &Record{Time: time.Now(), Message: "d\n"})); !strings.HasSuffix(msg, " - - d") {
+		t.Fatal(msg)
+	}
+}
+
+func TestSyslogUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.sock")
+	c, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+
+	h, err := NewSyslogHandler("unixgram", path, FacilityUser, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for level := LevelTrace; level <= LevelFatal; level++ {
+		h.Handle(&Record{Time: time.Now(), Level: level, Message: "m"})
+
+		want := "<" + strconv.Itoa(FacilityUser<<3|Severity(level)) + ">1 "
+		if msg := readPacket(t, c); !strings.HasPrefix(msg, want) {
+			t.Fatal(level, msg)
+		}
+	}
+
+	if Severity(LevelTrace) != SeverityDebug || Severity(LevelWarn) != SeverityWarning || Severity(LevelFatal) != SeverityCrit {
+		t.Fatal("bad severity")
+	}
+
+	if _, err := NewSyslogHandler("udp", "127.0.0.1:514", 24, "app"); err == nil {
+		t.Fatal("must error")
+	}
+}
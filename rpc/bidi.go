@@ -0,0 +1,50 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// seqReverse is set in seq of calls made by server, so calls of both sides
+// can share one connection without seq conflicts.
+const seqReverse uint32 = 1 << 31
+
+var errPeerClosed = errors.New("rpc peer closed")
+
+// Register registers a function which the server can call over the
+// connections of the client, see Peer.MakeRpc.
+func (c *Client) Register(name string, f interface{}) error {
+	return c.callbacks.Register(name, f)
+}
+
+// SetOnConnect sets a function called in a new goroutine when a client is connected,
+// the server can keep the peer to call the client later. It must be called before Start.
+func (s *Server) SetOnConnect(f func(p *Peer)) {
+	s.onConnect = f
+}
+
+// MakeRpc binds fptr to rpc name registered by the peer with Client.Register,
+// the call is made over the connection of the peer.
+//
+// A handler can call back the client of the call with the peer got by PeerFromContext,
+// and a function registered by Client.Register can call the server the same way.
+func (p *Peer) MakeRpc(rpcName string, fptr interface{}) error {
+	return makeRpc(p, rpcName, fptr)
+}
+
+// Done returns a channel closed when the connection of the peer is closed.
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *Peer) callInterceptors() []Interceptor {
+	return nil
+}
+
+func (p *Peer) invoke(ctx context.Context, name string, args []interface{}, ft reflect.Type) ([]interface{}, error) {
+	if p.out == nil {
+		return nil, NewError(CodeUnimplemented, "peer %s does not serve calls", p.Addr)
+	}
+	return p.out.roundTrip(ctx, name, args, ft)
+}
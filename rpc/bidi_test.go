@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBidirectional(t *testing.T) {
+	peers := make(chan *Peer, 1)
+
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.SetOnConnect(func(p *Peer) {
+		var notify func(string) error
+		if err := p.MakeRpc("client_notify", &notify); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := notify("hello"); err != nil {
+			t.Error(err)
+		}
+		peers <- p
+	})
+	s.Register("double", func(a int) (int, error) {
+		return 2 * a, nil
+	})
+	s.Register("ask", func(ctx context.Context, a int) (int, error) {
+		p, _ := PeerFromContext(ctx)
+
+		var add func(context.Context, int) (int, error)
+		if err := p.MakeRpc("client_add", &add); err != nil {
+			return 0, err
+		}
+		return add(ctx, a)
+	})
+	s.Register("sum", func(ctx context.Context, n int) (int, error) {
+		p, _ := PeerFromContext(ctx)
+
+		var count func(context.Context, int) (<-chan int, error)
+		if err := p.MakeRpc("client_count", &count); err != nil {
+			return 0, err
+		}
+
+		ch, err := count(ctx, n)
+		if err != nil {
+			return 0, err
+		}
+
+		sum := 0
+		for v := range ch {
+			sum += v
+		}
+		return sum, nil
+	})
+	addr := startTestServer(t, s)
+
+	notified := make(chan string, 1)
+
+	c := NewClient("tcp", addr, 1)
+	c.Register("client_notify", func(msg string) error {
+		notified <- msg
+		return nil
+	})
+	c.Register("client_add", func(ctx context.Context, a int) (int, error) {
+		// call back the server over the same connection
+		p, _ := PeerFromContext(ctx)
+
+		var double func(int) (int, error)
+		if err := p.MakeRpc("double", &double); err != nil {
+			return 0, err
+		}
+		d, err := double(a)
+		return d + 1, err
+	})
+	c.Register("client_count", func(n int) (<-chan int, error) {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			for i := 1; i <= n; i++ {
+				ch <- i
+			}
+		}()
+		return ch, nil
+	})
+
+	var ask func(int) (int, error)
+	if err := c.MakeRpc("ask", &ask); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := ask(10); err != nil {
+		t.Fatal(err)
+	} else if a != 21 {
+		t.Fatal(a)
+	}
+
+	var sum func(int) (int, error)
+	if err := c.MakeRpc("sum", &sum); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := sum(100); err != nil {
+		t.Fatal(err)
+	} else if a != 5050 {
+		t.Fatal(a)
+	}
+
+	select {
+	case msg := <-notified:
+		if msg != "hello" {
+			t.Fatal(msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not notified")
+	}
+
+	p := <-peers
+
+	var missing func() error
+	p.MakeRpc("client_missing", &missing)
+	if err := missing(); CodeOf(err) != CodeUnimplemented {
+		t.Fatal(err)
+	}
+
+	c.Close()
+
+	select {
+	case <-p.Done():
+	case <-time.After(time.Second):
+		t.Fatal("peer must be done")
+	}
+}
@@ -0,0 +1,108 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// caller is implemented by Client and MultiClient, a function bound by MakeRpc
+// calls through it.
+type caller interface {
+	callInterceptors() []Interceptor
+
+	// invoke calls rpc name bound to function type ft, retries included
+	invoke(ctx context.Context, name string, args []interface{}, ft reflect.Type) ([]interface{}, error)
+}
+
+func makeRpc(cl caller, rpcName string, fptr interface{}) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("make rpc error")
+		}
+	}()
+
+	fn := reflect.ValueOf(fptr).Elem()
+
+	nOut := fn.Type().NumOut()
+	if nOut == 0 || fn.Type().Out(nOut-1).Kind() != reflect.Interface {
+		err = fmt.Errorf("%s return final output param must be error interface", rpcName)
+		return
+	}
+
+	_, b := fn.Type().Out(nOut - 1).MethodByName("Error")
+	if !b {
+		err = fmt.Errorf("%s return final output param must be error interface", rpcName)
+		return
+	}
+
+	if err = checkStreams(rpcName, fn.Type()); err != nil {
+		return
+	}
+
+	if streamIndex(resultTypes(fn.Type())) >= 0 && !hasContext(fn.Type()) {
+		// the only way to stop a stream not read to end
+		err = fmt.Errorf("%s with streaming result must take a context.Context", rpcName)
+		return
+	}
+
+	f := func(in []reflect.Value) []reflect.Value {
+		return callRpc(cl, fn, rpcName, in)
+	}
+
+	v := reflect.MakeFunc(fn.Type(), f)
+	fn.Set(v)
+
+	return
+}
+
+func callRpc(cl caller, fn reflect.Value, name string, in []reflect.Value) []reflect.Value {
+	ctx := context.Background()
+	if hasContext(fn.Type()) {
+		if v, _ := in[0].Interface().(context.Context); v != nil {
+			ctx = v
+		}
+		in = in[1:]
+	}
+
+	inArgs := make([]interface{}, len(in))
+	for i := 0; i < len(in); i++ {
+		inArgs[i] = in[i].Interface()
+	}
+
+	types := resultTypes(fn.Type())
+
+	invoke := chainInterceptors(cl.callInterceptors(), func(ctx context.Context, name string, args []interface{}) ([]interface{}, error) {
+		return cl.invoke(ctx, name, args, fn.Type())
+	})
+
+	out, err := invoke(ctx, name, inArgs)
+	if err != nil {
+		return returnCallError(fn, err)
+	}
+
+	if len(out) != len(types) {
+		return returnCallError(fn, fmt.Errorf("rpc %s returns %d results, not %d", name, len(out), len(types)))
+	}
+
+	outValues := make([]reflect.Value, len(out)+1)
+	for i := 0; i < len(out); i++ {
+		if outValues[i], err = valueOf(out[i], types[i]); err != nil {
+			return returnCallError(fn, NewError(CodeInvalidArgument, "rpc %s result %d: %v", name, i, err))
+		}
+	}
+	outValues[len(out)] = reflect.Zero(fn.Type().Out(len(out)))
+
+	return outValues
+}
+
+func returnCallError(fn reflect.Value, err error) []reflect.Value {
+	nOut := fn.Type().NumOut()
+	out := make([]reflect.Value, nOut)
+	for i := 0; i < nOut-1; i++ {
+		out[i] = reflect.Zero(fn.Type().Out(i))
+	}
+
+	out[nOut-1] = reflect.ValueOf(&err).Elem()
+	return out
+}
@@ -0,0 +1,60 @@
+package rpc
+
+import (
+	"github.com/siddontang/go/bson"
+)
+
+// bsonCodec encodes messages as a BSON document.
+type bsonCodec struct {
+}
+
+type bsonMessage struct {
+	Name     string        `bson:"name"`
+	Args     []interface{} `bson:"args"`
+	Timeout  int64         `bson:"timeout,omitempty"`
+	Metadata Metadata      `bson:"metadata,omitempty"`
+	Error    *RpcError     `bson:"error,omitempty"`
+}
+
+type bsonRawMessage struct {
+	Name     string     `bson:"name"`
+	Args     []bson.Raw `bson:"args"`
+	Timeout  int64      `bson:"timeout,omitempty"`
+	Metadata Metadata   `bson:"metadata,omitempty"`
+	Error    *RpcError  `bson:"error,omitempty"`
+}
+
+// bson kind for null
+const bsonNull = 0x0A
+
+func (bsonCodec) Name() string {
+	return "bson"
+}
+
+func (bsonCodec) Encode(m *Message) ([]byte, error) {
+	return bson.Marshal(&bsonMessage{Name: m.Name, Args: m.Args, Timeout: m.Timeout, Metadata: m.Metadata, Error: m.Error})
+}
+
+func (bsonCodec) Decode(data []byte, m *Message, types TypesFunc) error {
+	var bm bsonRawMessage
+	if err := bson.Unmarshal(data, &bm); err != nil {
+		return err
+	}
+
+	args, err := decodeArgs(len(bm.Args), types(bm.Name), func(i int, v interface{}) error {
+		if bm.Args[i].Kind == bsonNull {
+			return nil
+		}
+		return bm.Args[i].Unmarshal(v)
+	})
+	m.Name = bm.Name
+	if err != nil {
+		return err
+	}
+
+	m.Args = args
+	m.Timeout = bm.Timeout
+	m.Metadata = bm.Metadata
+	m.Error = bm.Error
+	return nil
+}
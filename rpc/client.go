@@ -1,10 +1,16 @@
 package rpc
 
 import (
-	"container/list"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
+
+	"github.com/siddontang/go/log"
+	"github.com/siddontang/go/sync2"
 )
 
 type Client struct {
@@ -13,169 +19,589 @@ type Client struct {
 	network string
 	addr    string
 
-	maxIdleConns int
+	//max connections calls are multiplexed over
+	maxConns int
+
+	conns   []*clientConn
+	dialing int
+	// closed when the first connection is dialed, nil if not dialing
+	firstDial chan struct{}
+
+	// codecs offered to server in preference order
+	codecs []Codec
+
+	tlsConfig *tls.Config
+
+	// see SetCompression
+	compressThreshold int
+
+	interceptors []Interceptor
 
-	conns *list.List
+	retrier
+
+	// see SetCheckSignature
+	checkSignature bool
+	descs          []MethodDesc
+
+	// functions called by server, see Register
+	callbacks *Server
+
+	logger *log.Logger
+
+	// see SetMaxMessageSize and SetTimeouts
+	limits connLimits
+
+	dials        sync2.AtomicInt64
+	dialFailures sync2.AtomicInt64
+	stats        stats
 }
 
-func NewClient(network, addr string, maxIdleConns int) *Client {
+func NewClient(network, addr string, maxConns int) *Client {
 	RegisterType(RpcError{})
+	RegisterType([]MethodDesc{})
 
 	c := new(Client)
 	c.network = network
 	c.addr = addr
 
-	c.maxIdleConns = maxIdleConns
+	if maxConns <= 0 {
+		maxConns = 1
+	}
+	c.maxConns = maxConns
 
-	c.conns = list.New()
+	c.conns = make([]*clientConn, 0, maxConns)
+
+	c.codecs = []Codec{GobCodec}
+
+	c.retrier.init()
+
+	c.callbacks = NewServer(network, addr)
+
+	c.logger = defaultLogger
+
+	c.limits = defaultLimits
 
 	return c
 }
 
+// Use appends interceptors to the client, a call made by a function bound by MakeRpc
+// passes through them in order, retries happen after all interceptors.
+func (c *Client) Use(interceptors ...Interceptor) {
+	c.Lock()
+	c.interceptors = append(c.interceptors, interceptors...)
+	c.Unlock()
+}
+
+// SetCodecs sets the codecs offered to server in preference order,
+// the server picks the first one it supports. Only new connections are affected.
+func (c *Client) SetCodecs(codecs ...Codec) {
+	c.Lock()
+	c.codecs = codecs
+	c.Unlock()
+}
+
 func (c *Client) Close() error {
 	c.Lock()
+	conns := c.conns
+	c.conns = make([]*clientConn, 0, c.maxConns)
+	c.Unlock()
 
-	for {
-		if c.conns.Len() > 0 {
-			v := c.conns.Front()
+	for _, co := range conns {
+		co.close(errClientClosed)
+	}
 
-			co := v.Value.(*conn)
-			co.Close()
-			c.conns.Remove(v)
-		} else {
-			break
+	return nil
+}
+
+// MakeRpc binds function pointer fptr to rpc name, the final result of the function
+// must be error. If the first param is context.Context, its deadline and cancellation
+// apply to the call.
+func (c *Client) MakeRpc(rpcName string, fptr interface{}) error {
+	c.Lock()
+	check := c.checkSignature
+	c.Unlock()
+
+	if !check {
+		return makeRpc(c, rpcName, fptr)
+	}
+
+	// bind to a new one, fptr is set only if the signature is valid
+	v := reflect.ValueOf(fptr)
+	if v.Kind() != reflect.Ptr {
+		return fmt.Errorf("make rpc error")
+	}
+
+	f := reflect.New(v.Type().Elem())
+	if err := makeRpc(c, rpcName, f.Interface()); err != nil {
+		return err
+	}
+
+	descs, err := c.describeCached(false)
+	if err != nil {
+		return err
+	}
+
+	if !hasMethod(descs, rpcName) {
+		// may be registered after fetched
+		if descs, err = c.describeCached(true); err != nil {
+			return err
 		}
 	}
 
-	c.Unlock()
+	if err = checkSignature(descs, rpcName, f.Elem().Type()); err != nil {
+		return err
+	}
+
+	v.Elem().Set(f.Elem())
 	return nil
 }
 
-func (c *Client) MakeRpc(rpcName string, fptr interface{}) (err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			err = fmt.Errorf("make rpc error")
+func (c *Client) callInterceptors() []Interceptor {
+	c.Lock()
+	defer c.Unlock()
+	return c.interceptors
+}
+
+// invoke calls rpc name bound to function type ft and retries by the retry policy.
+func (c *Client) invoke(ctx context.Context, name string, args []interface{}, ft reflect.Type) ([]interface{}, error) {
+	return c.retry(ctx, name, ft, func() ([]interface{}, error) {
+		return c.roundTrip(ctx, name, args, ft)
+	})
+}
+
+// roundTrip sends one call and waits for its reply.
+func (c *Client) roundTrip(ctx context.Context, name string, args []interface{}, ft reflect.Type) ([]interface{}, error) {
+	co, err := c.getConn(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.getLogger().Warnf("rpc %s dial %s error %v", name, c.addr, err)
 		}
+		c.stats.record(name, 0, 0, 0, err)
+		return nil, notSentError{err}
+	}
+
+	return co.roundTrip(ctx, name, args, ft)
+}
+
+// roundTrip sends one call over the connection and waits for its reply.
+func (co *clientConn) roundTrip(ctx context.Context, name string, args []interface{}, ft reflect.Type) (out []interface{}, err error) {
+	var sent, received int
+	start := time.Now()
+	defer func() {
+		co.stats.record(name, received, sent, time.Since(start), err)
 	}()
 
-	fn := reflect.ValueOf(fptr).Elem()
+	args, src, err := splitStream(paramTypes(ft), args)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{Name: name, Args: args, Timeout: contextTimeout(ctx), Metadata: MetadataFromContext(ctx)}
 
-	nOut := fn.Type().NumOut()
-	if nOut == 0 || fn.Type().Out(nOut-1).Kind() != reflect.Interface {
-		err = fmt.Errorf("%s return final output param must be error interface", rpcName)
-		return
+	data, err := co.codec.Encode(m)
+	if err != nil {
+		return nil, err
+	} else if err = co.checkWrite(data); err != nil {
+		return nil, err
 	}
+	sent = len(data)
 
-	_, b := fn.Type().Out(nOut - 1).MethodByName("Error")
-	if !b {
-		err = fmt.Errorf("%s return final output param must be error interface", rpcName)
-		return
+	types := resultTypes(ft)
+
+	var st *stream
+	if streamIndex(types) >= 0 {
+		// ack and cancel are set with the seq in Call
+		st = newStream(nil)
+	}
+
+	buf, err := co.Call(ctx, name, data, src, st)
+	if err != nil {
+		return nil, err
+	}
+	received = len(buf)
+
+	d := new(Message)
+	if err = co.codec.Decode(buf, d, func(string) []reflect.Type { return wireTypes(types) }); err == nil {
+		if d.Name != name {
+			err = fmt.Errorf("rpc name %s != %s", d.Name, name)
+		} else if d.Error != nil {
+			err = *d.Error
+		}
+	}
+
+	if err != nil {
+		if st != nil {
+			co.removeStream(st, err)
+		}
+		return nil, err
 	}
 
-	f := func(in []reflect.Value) []reflect.Value {
-		return c.call(fn, rpcName, in)
+	if st == nil {
+		return d.Args, nil
 	}
 
-	v := reflect.MakeFunc(fn.Type(), f)
-	fn.Set(v)
+	go co.watchStream(ctx, st)
 
-	return
+	return joinStream(types, d.Args, st, co.codec), nil
 }
 
-func (c *Client) call(fn reflect.Value, name string, in []reflect.Value) []reflect.Value {
-	inArgs := make([]interface{}, len(in))
-	for i := 0; i < len(in); i++ {
-		inArgs[i] = in[i].Interface()
+// getConn returns the connection with the fewest pending calls,
+// a new one is dialed only if all are busy and maxConns is not reached.
+func (c *Client) getConn(ctx context.Context) (*clientConn, error) {
+	c.Lock()
+
+	for len(c.conns) == 0 && c.firstDial != nil {
+		// every call needs the connection being dialed, wait for it
+		ch := c.firstDial
+		c.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		c.Lock()
 	}
 
-	data, err := encodeData(name, inArgs)
-	if err != nil {
-		return c.returnCallError(fn, err)
+	codecs := c.codecs
+	tlsConfig := c.tlsConfig
+	compressThreshold := c.compressThreshold
+	limits := c.limits
+
+	var co *clientConn
+	for _, cc := range c.conns {
+		if co == nil || cc.pendingNum() < co.pendingNum() {
+			co = cc
+		}
 	}
 
-	var co *conn
-	var buf []byte
-	for i := 0; i < 3; i++ {
-		if co, err = c.popConn(); err != nil {
-			continue
+	if co != nil && (co.pendingNum() == 0 || len(c.conns)+c.dialing >= c.maxConns) {
+		c.Unlock()
+		return co, nil
+	}
+
+	var first chan struct{}
+	if co == nil {
+		first = make(chan struct{})
+		c.firstDial = first
+	}
+
+	c.dialing++
+	c.Unlock()
+
+	cc, err := c.dial(ctx, codecs, tlsConfig, compressThreshold, limits)
+
+	c.Lock()
+	c.dialing--
+	if err == nil {
+		c.conns = append(c.conns, cc)
+	}
+	if first != nil {
+		c.firstDial = nil
+		close(first)
+	}
+	c.Unlock()
+
+	if err != nil {
+		if co == nil {
+			return nil, err
 		}
+		//use the busy one instead
+		return co, nil
+	}
+	return cc, nil
+}
+
+func (c *Client) dial(ctx context.Context, codecs []Codec, tlsConfig *tls.Config, compressThreshold int, limits connLimits) (*clientConn, error) {
+	c.dials.Add(1)
+
+	co, err := newConn(ctx, c.network, c.addr, tlsConfig)
+	if err != nil {
+		c.dialFailures.Add(1)
+		return nil, err
+	}
+	co.maxRead, co.maxWrite = limits.maxRead, limits.maxWrite
+
+	if err = co.clientHandshake(ctx, codecs, compressThreshold); err != nil {
+		c.dialFailures.Add(1)
+		return nil, err
+	}
+
+	// handshake is bounded by ctx instead
+	co.idleTimeout, co.readTimeout = limits.idleTimeout, limits.readTimeout
+
+	cc := newClientConn(c, co, 0)
+	cc.stats = &c.stats
+
+	// calls from server, see Client.Register
+	cc.in = newServerConn(c.callbacks, co)
+	if cc.in.peer, err = newPeer(co.co); err != nil {
+		co.Close()
+		return nil, err
+	}
+	cc.in.peer.out = cc
+
+	go cc.run()
+
+	return cc, nil
+}
 
-		buf, err = co.Call(data)
-		if err == nil {
-			c.pushConn(co)
+func (c *Client) removeConn(co *clientConn) {
+	c.Lock()
+	for i, cc := range c.conns {
+		if cc == co {
+			copy(c.conns[i:], c.conns[i+1:])
+			c.conns[len(c.conns)-1] = nil
+			c.conns = c.conns[:len(c.conns)-1]
 			break
-		} else {
-			co.Close()
 		}
 	}
+	c.Unlock()
+}
 
-	if err != nil {
-		return c.returnCallError(fn, err)
+var errClientClosed = errors.New("rpc client closed")
+
+// clientConn multiplexes calls over one connection,
+// responses are matched to calls by seq and may arrive out of order.
+type clientConn struct {
+	*conn
+
+	// nil if the calls are made by server through Peer
+	c *Client
+
+	// serves calls from the other side, nil for server
+	in *serverConn
+
+	mutex sync.Mutex
+	seq   uint32
+	// set in seq of calls made by this side, see seqReverse
+	seqFlag uint32
+
+	// nil if not recorded
+	stats *stats
+
+	pending map[uint32]chan []byte
+	closed  bool
+	err     error
+
+	// streaming results of calls
+	streams map[uint32]*stream
+}
+
+func newClientConn(c *Client, co *conn, seqFlag uint32) *clientConn {
+	cc := new(clientConn)
+	cc.conn = co
+	cc.c = c
+	cc.seqFlag = seqFlag
+	cc.pending = make(map[uint32]chan []byte)
+	cc.streams = make(map[uint32]*stream)
+	return cc
+}
+
+// busy returns whether calls of either side are running on the connection.
+func (cc *clientConn) busy() bool {
+	cc.mutex.Lock()
+	n := len(cc.pending) + len(cc.streams)
+	cc.mutex.Unlock()
+
+	return n > 0 || (cc.in != nil && cc.in.calls() > 0)
+}
+
+func (cc *clientConn) pendingNum() int {
+	cc.mutex.Lock()
+	n := len(cc.pending)
+	cc.mutex.Unlock()
+	return n
+}
+
+// Call sends data and waits for the response, if ctx is done first,
+// the server is told to cancel the call.
+//
+// If src is valid, its elements are streamed after data until the response arrives.
+// If st is not nil, it receives the streaming result after the response.
+func (cc *clientConn) Call(ctx context.Context, name string, data []byte, src reflect.Value, st *stream) ([]byte, error) {
+	ch := make(chan []byte, 1)
+
+	cc.mutex.Lock()
+	if cc.closed {
+		err := cc.err
+		cc.mutex.Unlock()
+		return nil, notSentError{err}
+	}
+	cc.seq = (cc.seq + 1) &^ seqReverse
+	seq := cc.seq | cc.seqFlag
+	cc.pending[seq] = ch
+	if st != nil {
+		st.ack = func(n int) { cc.ackStream(seq, n) }
+		st.cancel = func(err error) { cc.cancelStream(seq, st, err) }
+		cc.streams[seq] = st
 	}
+	cc.mutex.Unlock()
 
-	n, out, e := decodeData(buf)
-	if e != nil {
-		return c.returnCallError(fn, e)
+	if err := cc.WriteMessage(seq, msgCall, data); err != nil {
+		cc.close(err)
+		return nil, err
 	}
 
-	if n != name {
-		return c.returnCallError(fn, fmt.Errorf("rpc name %s != %s", n, name))
+	if src.IsValid() {
+		done := make(chan struct{})
+		defer close(done)
+
+		go sendStream(cc.conn, seq, name, src, done)
 	}
 
-	last := out[len(out)-1]
-	if last != nil {
-		if err, ok := last.(error); ok {
-			return c.returnCallError(fn, err)
-		} else {
-			return c.returnCallError(fn, fmt.Errorf("rpc final return type %T must be error", last))
+	select {
+	case buf, ok := <-ch:
+		if !ok {
+			return nil, cc.err
+		}
+		return buf, nil
+	case <-ctx.Done():
+		cc.mutex.Lock()
+		delete(cc.pending, seq)
+		delete(cc.streams, seq)
+		cc.mutex.Unlock()
+
+		if st != nil {
+			st.abort(ctx.Err())
 		}
+
+		cc.WriteMessage(seq, msgCancel, nil)
+		return nil, ctx.Err()
 	}
+}
 
-	outValues := make([]reflect.Value, len(out))
-	for i := 0; i < len(out); i++ {
-		if out[i] == nil {
-			outValues[i] = reflect.Zero(fn.Type().Out(i))
-		} else {
-			outValues[i] = reflect.ValueOf(out[i])
+func (cc *clientConn) removeStream(st *stream, err error) {
+	cc.mutex.Lock()
+	for seq, s := range cc.streams {
+		if s == st {
+			delete(cc.streams, seq)
+			break
 		}
 	}
+	cc.mutex.Unlock()
 
-	return outValues
+	st.abort(err)
 }
 
-func (c *Client) returnCallError(fn reflect.Value, err error) []reflect.Value {
-	nOut := fn.Type().NumOut()
-	out := make([]reflect.Value, nOut)
-	for i := 0; i < nOut-1; i++ {
-		out[i] = reflect.Zero(fn.Type().Out(i))
+// cancelStream aborts the streaming result of call seq with err,
+// the server is told to cancel the call if the stream is running.
+func (cc *clientConn) cancelStream(seq uint32, st *stream, err error) {
+	cc.mutex.Lock()
+	found := cc.streams[seq] == st
+	if found {
+		delete(cc.streams, seq)
 	}
+	cc.mutex.Unlock()
+
+	st.abort(err)
 
-	out[nOut-1] = reflect.ValueOf(&err).Elem()
-	return out
+	if found {
+		cc.WriteMessage(seq, msgCancel, nil)
+	}
 }
 
-func (c *Client) popConn() (*conn, error) {
-	c.Lock()
-	if c.conns.Len() > 0 {
-		v := c.conns.Front()
-		c.conns.Remove(v)
-		c.Unlock()
+// watchStream cancels the call if ctx is done before the stream ends.
+func (cc *clientConn) watchStream(ctx context.Context, st *stream) {
+	if ctx.Done() == nil {
+		return
+	}
 
-		return v.Value.(*conn), nil
+	select {
+	case <-st.done:
+	case <-ctx.Done():
+		st.cancel(ctx.Err())
 	}
-	c.Unlock()
-	return newConn(c.network, c.addr)
 }
 
-func (c *Client) pushConn(co *conn) error {
-	c.Lock()
-	if c.conns.Len() >= c.maxIdleConns {
-		c.Unlock()
-		co.Close()
-		return nil
-	} else {
-		c.conns.PushBack(co)
+func (cc *clientConn) run() {
+	for {
+		seq, typ, data, err := cc.ReadMessage()
+		if err == errIdle && cc.busy() {
+			continue
+		} else if err != nil {
+			cc.close(err)
+			return
+		}
+
+		if cc.in != nil && seq&seqReverse != cc.seqFlag {
+			// a call made by server
+			err = cc.in.dispatch(seq, typ, data)
+		} else {
+			err = cc.dispatch(seq, typ, data)
+		}
+
+		if err != nil {
+			cc.close(err)
+			return
+		}
+	}
+}
+
+// dispatch handles a frame of a call made by this side.
+func (cc *clientConn) dispatch(seq uint32, typ byte, data []byte) error {
+	switch typ {
+	case msgReply:
+		cc.mutex.Lock()
+		ch, ok := cc.pending[seq]
+		delete(cc.pending, seq)
+		cc.mutex.Unlock()
+
+		if ok {
+			ch <- data
+		}
+	case msgStream, msgStreamEnd:
+		cc.mutex.Lock()
+		st, ok := cc.streams[seq]
+		if typ == msgStreamEnd {
+			delete(cc.streams, seq)
+		}
+		cc.mutex.Unlock()
+
+		if ok && !st.push(typ, data) {
+			// the server ignored the credit, only this call fails
+			st.cancel(&FrameError{seq, typ, len(data), "stream buffer overflow"})
+		}
+	case msgStreamAck:
+		return cc.grantStream(seq, data)
+	default:
+		return &FrameError{seq, typ, len(data), "unexpected message type"}
 	}
-	c.Unlock()
 	return nil
 }
+
+func (cc *clientConn) close(err error) {
+	cc.mutex.Lock()
+	if cc.closed {
+		cc.mutex.Unlock()
+		return
+	}
+	cc.closed = true
+	cc.err = err
+	for seq, ch := range cc.pending {
+		close(ch)
+		delete(cc.pending, seq)
+	}
+	streams := cc.streams
+	cc.streams = make(map[uint32]*stream)
+	cc.mutex.Unlock()
+
+	for _, st := range streams {
+		st.abort(err)
+	}
+
+	cc.conn.Close()
+
+	if cc.in != nil {
+		close(cc.in.peer.done)
+	}
+	if cc.c != nil {
+		if isClosed(err) {
+			cc.c.getLogger().Debugf("rpc conn %s closed", cc.c.addr)
+		} else {
+			cc.c.getLogger().Warnf("rpc conn %s error %v", cc.c.addr, err)
+		}
+		cc.c.removeConn(cc)
+	}
+}
@@ -4,16 +4,10 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"reflect"
 )
 
-type RpcError struct {
-	Message string
-}
-
-func (r RpcError) Error() string {
-	return r.Message
-}
-
+// RegisterType registers a concrete type passed as interface value, only GobCodec needs it.
 func RegisterType(value interface{}) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -24,40 +18,173 @@ func RegisterType(value interface{}) (err error) {
 	return
 }
 
-type rpcData struct {
+// Message is a call or a reply exchanged between client and server.
+type Message struct {
+	// rpc name
 	Name string
+
+	// call arguments or reply results except the final error
 	Args []interface{}
+
+	// reply error, nil if the call succeeded
+	Error *RpcError
+
+	// time left for the call in nanoseconds, 0 means no deadline. It is relative,
+	// so the clocks of client and server need not agree.
+	Timeout int64
+
+	// call metadata, see WithMetadata
+	Metadata Metadata
 }
 
-func encodeData(name string, args []interface{}) ([]byte, error) {
-	d := rpcData{}
-	d.Name = name
+// TypesFunc returns the types the args of the named message should be decoded to,
+// it returns nil if the name is unknown.
+type TypesFunc func(name string) []reflect.Type
+
+// Codec encodes and decodes messages, the codec used by a connection is negotiated
+// when it is established.
+type Codec interface {
+	// Name identifies the codec in the negotiation
+	Name() string
+
+	Encode(m *Message) ([]byte, error)
+
+	// Decode decodes data to m, codecs which are not self describing use types
+	// to decode the args. A nil arg must be decoded to nil.
+	Decode(data []byte, m *Message, types TypesFunc) error
+}
+
+var (
+	GobCodec  Codec = gobCodec{}
+	JSONCodec Codec = jsonCodec{}
+	BSONCodec Codec = bsonCodec{}
+)
 
-	d.Args = args
+var defaultCodecs = []Codec{GobCodec, JSONCodec, BSONCodec}
 
+// gobCodec encodes args with their types, so all concrete types passed as
+// interface values must be registered with RegisterType.
+type gobCodec struct {
+}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Encode(m *Message) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 
-	if err := enc.Encode(d); err != nil {
+	if err := enc.Encode(m); err != nil {
 		return nil, err
 	} else {
 		return buf.Bytes(), nil
 	}
 }
 
-func decodeData(data []byte) (name string, args []interface{}, err error) {
-	var d rpcData
-
+func (gobCodec) Decode(data []byte, m *Message, types TypesFunc) error {
 	var buf = bytes.NewBuffer(data)
 
 	dec := gob.NewDecoder(buf)
 
-	if err = dec.Decode(&d); err != nil {
-		return
+	return dec.Decode(m)
+}
+
+// decodeArgs decodes every arg with f to a new value of the matched type,
+// empty interface is used if no type matched. A failed arg is an InvalidArgument RpcError.
+func decodeArgs(n int, types []reflect.Type, f func(i int, v interface{}) error) ([]interface{}, error) {
+	args := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		t := emptyInterfaceType
+		if i < len(types) {
+			t = types[i]
+		}
+
+		v := reflect.New(t)
+		if err := f(i, v.Interface()); err != nil {
+			// the message is intact, only the call fails
+			return nil, NewError(CodeInvalidArgument, "decode arg %d error: %v", i, err)
+		}
+
+		args[i] = argValue(v.Elem())
 	}
+	return args, nil
+}
 
-	name = d.Name
-	args = d.Args
+var emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
 
-	return
+// argValue returns nil for a nil pointer, map, slice or interface,
+// so that a nil arg is not seen as a non nil interface value.
+func argValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+		if v.IsNil() {
+			return nil
+		}
+	}
+
+	return v.Interface()
+}
+
+// resultTypes returns the types of function type t results except the final error.
+func resultTypes(t reflect.Type) []reflect.Type {
+	types := make([]reflect.Type, t.NumOut()-1)
+	for i := 0; i < len(types); i++ {
+		types[i] = t.Out(i)
+	}
+	return types
+}
+
+// paramTypes returns the types of function type t params passed by client,
+// context is excluded.
+func paramTypes(t reflect.Type) []reflect.Type {
+	types := make([]reflect.Type, 0, t.NumIn())
+	for i := 0; i < t.NumIn(); i++ {
+		if i == 0 && t.In(i) == contextType {
+			continue
+		}
+
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			break
+		}
+
+		types = append(types, t.In(i))
+	}
+	return types
+}
+
+// argTypes returns the types of function type t params which are sent in the call message.
+func argTypes(t reflect.Type) []reflect.Type {
+	return wireTypes(paramTypes(t))
+}
+
+func findCodec(codecs []Codec, name string) Codec {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// valueOf returns arg as a value of type t, a pointer is flattened by some codecs,
+// e.g. gob, so it is restored here. Other types are not converted, e.g. an int
+// is not a string, an InvalidArgument error is returned instead.
+func valueOf(arg interface{}, t reflect.Type) (reflect.Value, error) {
+	if arg == nil {
+		return reflect.Zero(t), nil
+	}
+
+	v := reflect.ValueOf(arg)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+
+	if t.Kind() == reflect.Ptr && v.Type().AssignableTo(t.Elem()) {
+		p := reflect.New(t.Elem())
+		p.Elem().Set(v)
+		return p, nil
+	}
+
+	return reflect.Value{}, NewError(CodeInvalidArgument, "%s is not %s", v.Type(), t)
 }
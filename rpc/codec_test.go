@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"errors"
+	"testing"
+)
+
+type testCodecArg struct {
+	ID   int
+	Name string
+}
+
+func test_Codec(a testCodecArg, ids []int, m map[string]int) (*testCodecArg, []int, error) {
+	if a.ID == 0 {
+		return nil, nil, errors.New("invalid id")
+	}
+	return &a, ids, nil
+}
+
+func testCodec(t *testing.T, codec Codec) {
+	s := newTestServer()
+
+	s.Register("codec_"+codec.Name(), test_Codec)
+
+	c := NewClient("tcp", "127.0.0.1:11182", 1)
+	defer c.Close()
+
+	c.SetCodecs(codec)
+
+	var r func(testCodecArg, []int, map[string]int) (*testCodecArg, []int, error)
+	if err := c.MakeRpc("codec_"+codec.Name(), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	a, ids, e := r(testCodecArg{1, "a"}, []int{1, 2}, map[string]int{"a": 1})
+	if e != nil {
+		t.Fatal(e)
+	} else if a.ID != 1 || a.Name != "a" {
+		t.Fatal(a)
+	} else if len(ids) != 2 || ids[1] != 2 {
+		t.Fatal(ids)
+	}
+
+	a, ids, e = r(testCodecArg{}, nil, nil)
+	if e == nil {
+		t.Fatal("must error")
+	} else if e.Error() != "invalid id" {
+		t.Fatal(e)
+	} else if a != nil || ids != nil {
+		t.Fatal(a, ids)
+	}
+}
+
+func TestGobCodec(t *testing.T) {
+	RegisterType(map[string]int{})
+	RegisterType(testCodecArg{})
+	RegisterType(&testCodecArg{})
+	testCodec(t, GobCodec)
+}
+
+func TestJSONCodec(t *testing.T) {
+	testCodec(t, JSONCodec)
+}
+
+func TestBSONCodec(t *testing.T) {
+	testCodec(t, BSONCodec)
+}
+
+type testUnknownCodec struct {
+	gobCodec
+}
+
+func (testUnknownCodec) Name() string {
+	return "unknown"
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	s := newTestServer()
+
+	s.Register("codec_negotiation", test_Rpc1)
+
+	c := NewClient("tcp", "127.0.0.1:11182", 1)
+	defer c.Close()
+
+	c.SetCodecs(testUnknownCodec{})
+
+	var r func(int) (int, string, error)
+	if err := c.MakeRpc("codec_negotiation", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, e := r(10); e == nil {
+		t.Fatal("must error")
+	}
+
+	c.SetCodecs(testUnknownCodec{}, JSONCodec)
+	if _, _, e := r(10); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestCodecBadArg(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, BSONCodec} {
+		s := newTestServer()
+
+		s.Register("codec_bad_"+codec.Name(), test_Rpc1)
+
+		c := NewClient("tcp", "127.0.0.1:11182", 1)
+		c.SetCodecs(codec)
+
+		var r func(int) (int, string, error)
+		var bad func(string) (int, string, error)
+		if err := c.MakeRpc("codec_bad_"+codec.Name(), &r); err != nil {
+			t.Fatal(err)
+		} else if err = c.MakeRpc("codec_bad_"+codec.Name(), &bad); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, e := bad("10"); CodeOf(e) != CodeInvalidArgument {
+			t.Fatal(codec.Name(), e)
+		}
+
+		// the connection is kept for other calls
+		if a, _, e := r(10); e != nil || a != 100 {
+			t.Fatal(codec.Name(), a, e)
+		} else if n := c.dials.Get(); n != 1 {
+			t.Fatal(codec.Name(), n)
+		}
+
+		c.Close()
+	}
+}
+
+func TestCodecTypeMismatch(t *testing.T) {
+	s := newTestServer()
+	s.Register("codec_mismatch", test_Rpc1)
+
+	c := newTestClient()
+
+	// an int result is not converted to a string
+	var r func(int) (string, string, error)
+	if err := c.MakeRpc("codec_mismatch", &r); err != nil {
+		t.Fatal(err)
+	}
+	if a, _, e := r(65); e == nil || CodeOf(e) != CodeInvalidArgument {
+		t.Fatal(a, e)
+	}
+
+	// nor converted to a slice
+	var rs func(int) ([]int, string, error)
+	if err := c.MakeRpc("codec_mismatch", &rs); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, e := rs(1); e == nil {
+		t.Fatal("must error")
+	}
+
+	// a wrong arg is refused by server
+	var bad func(string) (int, string, error)
+	if err := c.MakeRpc("codec_mismatch", &bad); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, e := bad("a"); CodeOf(e) != CodeInvalidArgument {
+		t.Fatal(e)
+	}
+}
@@ -0,0 +1,41 @@
+package rpc
+
+import (
+	"github.com/siddontang/go/snappy"
+)
+
+// flagCompressed is set in the type byte of a frame whose data is compressed by snappy.
+const flagCompressed byte = 0x80
+
+// compression negotiated in handshake
+const snappyCompression = "snappy"
+
+// compress returns the data to write and whether it is compressed,
+// data is compressed only if it is large enough and gets smaller.
+func (c *conn) compress(data []byte) ([]byte, bool) {
+	if c.compressThreshold <= 0 || len(data) < c.compressThreshold {
+		return data, false
+	}
+
+	buf, err := snappy.Encode(nil, data)
+	if err != nil || len(buf) >= len(data) {
+		return data, false
+	}
+	return buf, true
+}
+
+// SetCompression enables snappy compression for connections to clients which
+// support it, a frame whose data is at least threshold bytes is compressed.
+// threshold <= 0 disables it, the default. It must be called before Start.
+func (s *Server) SetCompression(threshold int) {
+	s.compressThreshold = threshold
+}
+
+// SetCompression offers snappy compression to server, if the server enables it
+// a frame whose data is at least threshold bytes is compressed.
+// threshold <= 0 disables it, the default. Only new connections are affected.
+func (c *Client) SetCompression(threshold int) {
+	c.Lock()
+	c.compressThreshold = threshold
+	c.Unlock()
+}
@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestConnCompress(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	w := &conn{co: c1, compressThreshold: 16}
+	r := &conn{co: c2}
+
+	data := bytes.Repeat([]byte("a"), 1000)
+
+	go w.WriteMessage(1, msgCall, data)
+
+	h := make([]byte, headerLen)
+	if _, err := io.ReadFull(c2, h); err != nil {
+		t.Fatal(err)
+	}
+	length := binary.LittleEndian.Uint32(h[0:4])
+	if h[8] != msgCall|flagCompressed || length >= uint32(len(data)) {
+		t.Fatal(h[8], length)
+	}
+	io.ReadFull(c2, make([]byte, length))
+
+	// small frames are not compressed
+	for _, d := range [][]byte{data, []byte("small")} {
+		go w.WriteMessage(2, msgReply, d)
+
+		seq, typ, buf, err := r.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		} else if seq != 2 || typ != msgReply || !bytes.Equal(buf, d) {
+			t.Fatal(seq, typ, len(buf))
+		}
+	}
+}
+
+func TestRpcCompress(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.SetCompression(64)
+	s.Register("repeat", func(a string, n int) (string, error) {
+		return strings.Repeat(a, n), nil
+	})
+	addr := startTestServer(t, s)
+
+	for _, threshold := range []int{0, 64} {
+		c := NewClient("tcp", addr, 1)
+		c.SetCompression(threshold)
+
+		var r func(string, int) (string, error)
+		if err := c.MakeRpc("repeat", &r); err != nil {
+			t.Fatal(err)
+		}
+
+		if a, err := r("abc", 10000); err != nil {
+			t.Fatal(err)
+		} else if a != strings.Repeat("abc", 10000) {
+			t.Fatal(len(a))
+		}
+
+		co, err := c.getConn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if co.conn.compressThreshold != threshold {
+			t.Fatal(co.conn.compressThreshold, threshold)
+		}
+
+		c.Close()
+	}
+}
@@ -1,18 +1,73 @@
 package rpc
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
+
+	"github.com/siddontang/go/snappy"
+)
+
+// message frame: length(4 bytes) | seq(4 bytes) | type(1 byte) | data, little endian.
+// length is the data length, seq is used to match a response to its request,
+// so many calls can share one connection. The high bit of type is set if data
+// is compressed.
+const headerLen = 9
+
+// message types
+const (
+	msgCall byte = iota
+	msgReply
+	// cancel the call with the same seq, no data
+	msgCancel
+	// connection negotiation, see handshake
+	msgHandshake
+	// an element of a streaming param or result, see stream
+	msgStream
+	// end of stream, with an optional error
+	msgStreamEnd
+	// credit of a stream, the number of frames the sender may send more, uint32
+	msgStreamAck
 )
 
 type conn struct {
 	co net.Conn
+
+	// negotiated in handshake
+	codec Codec
+
+	// frames not smaller are compressed, 0 means no compression, see SetCompression
+	compressThreshold int
+
+	// the client serves calls from server, set in handshake
+	callbacks bool
+
+	connLimits
+
+	wMutex sync.Mutex
+
+	// credits of the streams sent, see sendStream
+	sMutex  sync.Mutex
+	credits map[uint32]*streamCredit
 }
 
-func newConn(network, addr string) (*conn, error) {
-	c, err := net.Dial(network, addr)
+// newConn dials addr, a TLS connection is used if tlsConfig is not nil.
+func newConn(ctx context.Context, network, addr string, tlsConfig *tls.Config) (*conn, error) {
+	var c net.Conn
+	var err error
+	if tlsConfig != nil {
+		d := tls.Dialer{Config: tlsConfig}
+		c, err = d.DialContext(ctx, network, addr)
+	} else {
+		var d net.Dialer
+		c, err = d.DialContext(ctx, network, addr)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -26,26 +81,24 @@ func (c *conn) Close() error {
 	return c.co.Close()
 }
 
-func (c *conn) Call(data []byte) ([]byte, error) {
-	if err := c.WriteMessage(data); err != nil {
-		return nil, err
+func (c *conn) WriteMessage(seq uint32, typ byte, data []byte) error {
+	if d, ok := c.compress(data); ok {
+		data = d
+		typ |= flagCompressed
 	}
 
-	if buf, err := c.ReadMessage(); err != nil {
-		return nil, err
-	} else {
-		return buf, nil
-	}
-}
-
-func (c *conn) WriteMessage(data []byte) error {
-	buf := make([]byte, 4+len(data))
+	buf := make([]byte, headerLen+len(data))
 
 	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(buf[4:8], seq)
+	buf[8] = typ
 
-	copy(buf[4:], data)
+	copy(buf[headerLen:], data)
 
+	c.wMutex.Lock()
 	n, err := c.co.Write(buf)
+	c.wMutex.Unlock()
+
 	if err != nil {
 		c.Close()
 		return err
@@ -56,23 +109,92 @@ func (c *conn) WriteMessage(data []byte) error {
 	return nil
 }
 
-func (c *conn) ReadMessage() ([]byte, error) {
-	l := make([]byte, 4)
+// readData reads n bytes of frame data, a large buffer grows as the data arrives,
+// so a header alone can't make it allocate the claimed length.
+func (c *conn) readData(n int) ([]byte, error) {
+	if n <= frameChunkSize {
+		data := make([]byte, n)
+		_, err := io.ReadFull(c.co, data)
+		return data, err
+	}
 
-	_, err := io.ReadFull(c.co, l)
+	var buf bytes.Buffer
+	buf.Grow(frameChunkSize)
+	if _, err := io.CopyN(&buf, c.co, int64(n)); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadMessage reads a frame, errIdle is returned without closing the connection
+// if no frame arrives in idle timeout.
+func (c *conn) ReadMessage() (uint32, byte, []byte, error) {
+	h := make([]byte, headerLen)
+
+	if c.idleTimeout > 0 {
+		c.co.SetReadDeadline(time.Now().Add(c.idleTimeout))
+	} else if c.readTimeout > 0 {
+		c.co.SetReadDeadline(time.Time{})
+	}
+
+	// the first byte waits for idle timeout, the others for read timeout
+	if _, err := io.ReadFull(c.co, h[:1]); err != nil {
+		if e, ok := err.(net.Error); ok && e.Timeout() && c.idleTimeout > 0 {
+			return 0, 0, nil, errIdle
+		}
+		c.Close()
+		return 0, 0, nil, err
+	}
+
+	if c.readTimeout > 0 {
+		c.co.SetReadDeadline(time.Now().Add(c.readTimeout))
+	} else if c.idleTimeout > 0 {
+		c.co.SetReadDeadline(time.Time{})
+	}
+
+	_, err := io.ReadFull(c.co, h[1:])
 	if err != nil {
 		c.Close()
-		return nil, err
+		return 0, 0, nil, err
 	}
 
-	length := binary.LittleEndian.Uint32(l)
+	length := binary.LittleEndian.Uint32(h[0:4])
+	seq := binary.LittleEndian.Uint32(h[4:8])
+	typ := h[8]
 
-	data := make([]byte, length)
-	_, err = io.ReadFull(c.co, data)
+	if typ&^flagCompressed > msgStreamAck {
+		c.Close()
+		return 0, 0, nil, &FrameError{seq, typ, int(length), "invalid message type"}
+	} else if c.maxRead > 0 && int64(length) > int64(c.maxRead) {
+		c.Close()
+		return 0, 0, nil, &FrameError{seq, typ, int(length), fmt.Sprintf("exceeds limit %d", c.maxRead)}
+	}
+
+	data, err := c.readData(int(length))
 	if err != nil {
 		c.Close()
-		return nil, err
-	} else {
-		return data, nil
+		return 0, 0, nil, err
+	}
+
+	if typ&flagCompressed != 0 {
+		typ &^= flagCompressed
+
+		// check the decoded length before allocating it
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			c.Close()
+			return 0, 0, nil, &FrameError{seq, typ, int(length), err.Error()}
+		} else if c.maxRead > 0 && n > c.maxRead {
+			c.Close()
+			return 0, 0, nil, &FrameError{seq, typ, n, fmt.Sprintf("decoded exceeds limit %d", c.maxRead)}
+		}
+
+		if data, err = snappy.Decode(nil, data); err != nil {
+			c.Close()
+			return 0, 0, nil, &FrameError{seq, typ, int(length), err.Error()}
+		}
 	}
+	return seq, typ, data, nil
 }
@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// hasContext reports whether the first param of function type t is context.Context,
+// the context is never sent across the wire, only its deadline is.
+func hasContext(t reflect.Type) bool {
+	return t.NumIn() > 0 && t.In(0) == contextType
+}
+
+// contextTimeout returns the time left before the deadline of ctx in nanoseconds,
+// 0 if it has none, at least 1 if it has passed.
+func contextTimeout(ctx context.Context) int64 {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+
+	if t := int64(time.Until(d)); t > 0 {
+		return t
+	}
+	return 1
+}
+
+// newCallContext returns a context derived from ctx for a received call carrying
+// the metadata the client sent, its deadline is the timeout sent from now.
+func newCallContext(ctx context.Context, m *Message) (context.Context, context.CancelFunc) {
+	if m.Metadata != nil {
+		ctx = WithMetadata(ctx, m.Metadata)
+	}
+
+	if m.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(m.Timeout))
+}
+
+// Metadata is sent along with a call, e.g. an authentication token or a request id.
+type Metadata map[string]string
+
+type metadataKey struct{}
+
+// WithMetadata returns a context carrying md, a call made with the context
+// sends md to server, where it can be got by MetadataFromContext.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
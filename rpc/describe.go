@@ -0,0 +1,159 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// DescribeName is the built-in rpc every server registers, it returns
+// the descriptions of all registered functions.
+const DescribeName = "rpc.Describe"
+
+// MethodDesc describes a registered function. Context param is omitted,
+// a streaming type is shown as "stream T" and the final result as "error".
+type MethodDesc struct {
+	Name string
+	In   []string
+	Out  []string
+}
+
+func (d MethodDesc) String() string {
+	return fmt.Sprintf("%s%v %v", d.Name, d.In, d.Out)
+}
+
+func typeName(t reflect.Type) string {
+	if isStreamType(t) {
+		return "stream " + streamElemType(t).String()
+	}
+	return t.String()
+}
+
+func describeFunc(name string, t reflect.Type) MethodDesc {
+	d := MethodDesc{Name: name}
+
+	for _, pt := range paramTypes(t) {
+		d.In = append(d.In, typeName(pt))
+	}
+
+	if t.IsVariadic() {
+		d.In = append(d.In, "..."+typeName(t.In(t.NumIn()-1).Elem()))
+	}
+
+	for _, rt := range resultTypes(t) {
+		d.Out = append(d.Out, typeName(rt))
+	}
+	d.Out = append(d.Out, "error")
+
+	return d
+}
+
+// Describe returns the descriptions of all registered functions sorted by name.
+func (s *Server) Describe() []MethodDesc {
+	s.Lock()
+	descs := make([]MethodDesc, 0, len(s.funcs))
+	for name, f := range s.funcs {
+		descs = append(descs, describeFunc(name, f.Type()))
+	}
+	s.Unlock()
+
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].Name < descs[j].Name
+	})
+	return descs
+}
+
+const describeTimeout = 10 * time.Second
+
+var describeType = reflect.TypeOf(func() ([]MethodDesc, error) { return nil, nil })
+
+// Describe returns the descriptions of all functions registered in the server.
+func (c *Client) Describe(ctx context.Context) ([]MethodDesc, error) {
+	out, err := c.invoke(ctx, DescribeName, nil, describeType)
+	if err != nil {
+		return nil, err
+	}
+
+	descs, _ := out[0].([]MethodDesc)
+	return descs, nil
+}
+
+func hasMethod(descs []MethodDesc, name string) bool {
+	for _, d := range descs {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSignature checks the function bound to rpc name has the signature
+// registered in server. Every server type "interface {}" matches any type.
+func checkSignature(descs []MethodDesc, name string, t reflect.Type) error {
+	var server *MethodDesc
+	for i := range descs {
+		if descs[i].Name == name {
+			server = &descs[i]
+			break
+		}
+	}
+
+	if server == nil {
+		return fmt.Errorf("rpc %s not registered", name)
+	}
+
+	client := describeFunc(name, t)
+
+	if !matchTypes(server.In, client.In) || !matchTypes(server.Out, client.Out) {
+		return fmt.Errorf("rpc %s signature mismatch, server %s, client %s", name, server, client)
+	}
+	return nil
+}
+
+func matchTypes(server []string, client []string) bool {
+	if len(server) != len(client) {
+		return false
+	}
+
+	for i := range server {
+		if server[i] != client[i] && server[i] != "interface {}" {
+			return false
+		}
+	}
+	return true
+}
+
+// SetCheckSignature makes MakeRpc check the bound function against the signature
+// registered in server, so a mismatch is an error at binding, not at call.
+// The server descriptions are cached and fetched again only if a name is not found.
+func (c *Client) SetCheckSignature(check bool) {
+	c.Lock()
+	c.checkSignature = check
+	c.Unlock()
+}
+
+func (c *Client) describeCached(refresh bool) ([]MethodDesc, error) {
+	c.Lock()
+	descs := c.descs
+	c.Unlock()
+
+	if descs != nil && !refresh {
+		return descs, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+
+	descs, err := c.Describe(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	c.descs = descs
+	c.Unlock()
+
+	return descs, nil
+}
@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	s := newTestServer()
+	s.Register("describe_rpc1", test_Rpc1)
+	s.Register("describe_stream", test_StreamSum)
+	s.Register("describe_ctx", test_Rpc5)
+
+	c := NewClient("tcp", "127.0.0.1:11182", 1)
+	defer c.Close()
+
+	descs, err := c.Describe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := 0
+	for _, d := range descs {
+		switch d.Name {
+		case "describe_rpc1":
+			if d.String() != "describe_rpc1[int] [int string error]" {
+				t.Fatal(d)
+			}
+			found++
+		case "describe_stream":
+			if d.String() != "describe_stream[int stream int] [int error]" {
+				t.Fatal(d)
+			}
+			found++
+		case "describe_ctx":
+			if d.String() != "describe_ctx[int] [int error]" {
+				t.Fatal(d)
+			}
+			found++
+		}
+	}
+
+	if found != 3 {
+		t.Fatal(descs)
+	}
+
+	c.SetCheckSignature(true)
+
+	var r1 func(int) (int, string, error)
+	if err := c.MakeRpc("describe_rpc1", &r1); err != nil {
+		t.Fatal(err)
+	}
+
+	var r2 func(string) (int, string, error)
+	if err := c.MakeRpc("describe_rpc1", &r2); err == nil {
+		t.Fatal("must error")
+	} else if r2 != nil {
+		t.Fatal("must not bind")
+	}
+
+	var r3 func(int, func() (int, bool, error)) (int, error)
+	if err := c.MakeRpc("describe_stream", &r3); err != nil {
+		t.Fatal(err)
+	}
+
+	var r4 func(context.Context, int) (int, error)
+	if err := c.MakeRpc("describe_ctx", &r4); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.MakeRpc("describe_not_exist", &r1); err == nil {
+		t.Fatal("must error")
+	}
+}
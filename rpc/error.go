@@ -0,0 +1,231 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/siddontang/go/tb"
+)
+
+// Code classifies an RpcError.
+type Code int
+
+const (
+	CodeOK Code = iota
+	CodeUnknown
+	CodeCanceled
+	CodeDeadlineExceeded
+	CodeInvalidArgument
+	CodeNotFound
+	CodeAlreadyExists
+	CodePermissionDenied
+	CodeUnauthenticated
+	CodeResourceExhausted
+	CodeFailedPrecondition
+	CodeUnimplemented
+	CodeInternal
+	CodeUnavailable
+)
+
+var codeNames = []string{
+	"OK",
+	"Unknown",
+	"Canceled",
+	"DeadlineExceeded",
+	"InvalidArgument",
+	"NotFound",
+	"AlreadyExists",
+	"PermissionDenied",
+	"Unauthenticated",
+	"ResourceExhausted",
+	"FailedPrecondition",
+	"Unimplemented",
+	"Internal",
+	"Unavailable",
+}
+
+func (c Code) String() string {
+	if c >= 0 && int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", int(c))
+}
+
+// RpcError is an error replied by server, it round-trips its code, details,
+// stack and cause, so errors.Is and errors.As work on the client.
+type RpcError struct {
+	Code    Code
+	Message string
+
+	// behind a pointer, so RpcError stays comparable with ==
+	Details *ErrorDetails
+
+	// stack trace of a tb.StackError, only sent if the server is set by SetSendStack
+	Stack string
+
+	Cause *RpcError
+}
+
+// ErrorDetails are key values describing an RpcError, see WithDetail.
+type ErrorDetails map[string]string
+
+// sentinel errors to check codes with errors.Is
+var (
+	ErrCanceled           = RpcError{Code: CodeCanceled}
+	ErrDeadlineExceeded   = RpcError{Code: CodeDeadlineExceeded}
+	ErrInvalidArgument    = RpcError{Code: CodeInvalidArgument}
+	ErrNotFound           = RpcError{Code: CodeNotFound}
+	ErrAlreadyExists      = RpcError{Code: CodeAlreadyExists}
+	ErrPermissionDenied   = RpcError{Code: CodePermissionDenied}
+	ErrUnauthenticated    = RpcError{Code: CodeUnauthenticated}
+	ErrResourceExhausted  = RpcError{Code: CodeResourceExhausted}
+	ErrFailedPrecondition = RpcError{Code: CodeFailedPrecondition}
+	ErrUnimplemented      = RpcError{Code: CodeUnimplemented}
+	ErrInternal           = RpcError{Code: CodeInternal}
+	ErrUnavailable        = RpcError{Code: CodeUnavailable}
+)
+
+// NewError returns an RpcError with code, a registered function can return it
+// to tell the client what happened.
+func NewError(code Code, format string, args ...interface{}) RpcError {
+	return RpcError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (r RpcError) Error() string {
+	return r.Message
+}
+
+// StackTrace implements tb.StackError.
+func (r RpcError) StackTrace() string {
+	return r.Stack
+}
+
+func (r RpcError) Unwrap() error {
+	if r.Cause == nil {
+		return nil
+	}
+	return *r.Cause
+}
+
+// Is reports whether target is an RpcError with the same code, and the same
+// message if target has one. A canceled or deadline exceeded error also
+// matches the context error.
+func (r RpcError) Is(target error) bool {
+	switch t := target.(type) {
+	case RpcError:
+		return r.Code == t.Code && (len(t.Message) == 0 || r.Message == t.Message)
+	case *RpcError:
+		return t != nil && r.Code == t.Code && (len(t.Message) == 0 || r.Message == t.Message)
+	}
+
+	switch target {
+	case context.Canceled:
+		return r.Code == CodeCanceled
+	case context.DeadlineExceeded:
+		return r.Code == CodeDeadlineExceeded
+	}
+	return false
+}
+
+// WithDetail returns a copy of r with detail key set to value.
+func (r RpcError) WithDetail(key, value string) RpcError {
+	details := make(ErrorDetails, len(r.details())+1)
+	for k, v := range r.details() {
+		details[k] = v
+	}
+	details[key] = value
+	r.Details = &details
+	return r
+}
+
+// Detail returns the detail of key, "" if it is not set.
+func (r RpcError) Detail(key string) string {
+	return r.details()[key]
+}
+
+func (r RpcError) details() ErrorDetails {
+	if r.Details == nil {
+		return nil
+	}
+	return *r.Details
+}
+
+// Coder can be implemented by an error to tell its code.
+type Coder interface {
+	RpcCode() Code
+}
+
+// CodeOf returns the code of err, CodeOK for nil, CodeUnavailable for an error
+// not replied by server, e.g. connection refused.
+func CodeOf(err error) Code {
+	if err == nil {
+		return CodeOK
+	}
+
+	var r RpcError
+	if errors.As(err, &r) {
+		if r.Code == CodeOK {
+			return CodeUnknown
+		}
+		return r.Code
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadlineExceeded
+	}
+
+	return CodeUnavailable
+}
+
+// max depth of causes sent to client
+const maxErrorCauses = 8
+
+// toRpcError converts err returned by a registered function to the RpcError sent to client.
+func toRpcError(err error, withStack bool) *RpcError {
+	return toRpcErrorDepth(err, withStack, 0)
+}
+
+func toRpcErrorDepth(err error, withStack bool, depth int) *RpcError {
+	var r RpcError
+	if e, ok := err.(RpcError); ok {
+		r = e
+	} else if e, ok := err.(*RpcError); ok && e != nil {
+		r = *e
+	} else {
+		r.Code = CodeUnknown
+		r.Message = err.Error()
+
+		if c, ok := err.(Coder); ok {
+			r.Code = c.RpcCode()
+		} else if err == context.Canceled {
+			r.Code = CodeCanceled
+		} else if err == context.DeadlineExceeded {
+			r.Code = CodeDeadlineExceeded
+		}
+
+		if s, ok := err.(tb.StackError); ok {
+			// tb.StackError appends the stack to the message
+			r.Message = strings.TrimSuffix(r.Message, "\n"+s.StackTrace())
+			r.Stack = s.StackTrace()
+		}
+
+		if cause := errors.Unwrap(err); cause != nil && depth < maxErrorCauses {
+			r.Cause = toRpcErrorDepth(cause, withStack, depth+1)
+		}
+	}
+
+	if r.Code == CodeOK {
+		r.Code = CodeUnknown
+	}
+
+	if !withStack {
+		r.Stack = ""
+	}
+
+	return &r
+}
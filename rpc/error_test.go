@@ -0,0 +1,144 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/siddontang/go/tb"
+)
+
+type testCodeError struct{}
+
+func (testCodeError) Error() string { return "denied" }
+func (testCodeError) RpcCode() Code { return CodePermissionDenied }
+
+func test_Error(kind string) error {
+	switch kind {
+	case "notfound":
+		return NewError(CodeNotFound, "key %s not found", "a").WithDetail("key", "a")
+	case "coder":
+		return testCodeError{}
+	case "wrap":
+		return fmt.Errorf("get: %w", NewError(CodeNotFound, "missing"))
+	case "plain":
+		return errors.New("plain")
+	case "stack":
+		return tb.Errorf("broken")
+	}
+	return nil
+}
+
+func testErrorCodec(t *testing.T, c *Client) {
+	var r func(string) error
+	if err := c.MakeRpc("rpc_error", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	err := r("notfound")
+	var e RpcError
+	if !errors.As(err, &e) {
+		t.Fatal(err)
+	} else if e.Code != CodeNotFound || e.Message != "key a not found" || e.Detail("key") != "a" {
+		t.Fatal(e)
+	} else if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrInternal) {
+		t.Fatal(err)
+	}
+
+	if err = r("coder"); CodeOf(err) != CodePermissionDenied || err.Error() != "denied" {
+		t.Fatal(err)
+	}
+
+	err = r("wrap")
+	if CodeOf(err) != CodeUnknown || err.Error() != "get: missing" {
+		t.Fatal(err)
+	} else if !errors.Is(err, ErrNotFound) {
+		t.Fatal("cause must be not found")
+	}
+
+	if err = r("plain"); CodeOf(err) != CodeUnknown {
+		t.Fatal(err)
+	}
+
+	if err = r("stack"); err.Error() != "broken" {
+		t.Fatal(err)
+	} else if err.(tb.StackError).StackTrace() != "" {
+		t.Fatal("stack must not be sent by default")
+	}
+
+	if err = r("ok"); err != nil {
+		t.Fatal(err)
+	}
+
+	var u func() error
+	c.MakeRpc("rpc_error_unregistered", &u)
+	if err = u(); !errors.Is(err, ErrUnimplemented) {
+		t.Fatal(err)
+	}
+}
+
+func TestRpcError(t *testing.T) {
+	RegisterType(map[string]string{})
+
+	s := newTestServer()
+	s.Register("rpc_error", test_Error)
+
+	for _, codec := range []Codec{GobCodec, JSONCodec, BSONCodec} {
+		c := NewClient("tcp", "127.0.0.1:11182", 1)
+		c.SetCodecs(codec)
+		testErrorCodec(t, c)
+		c.Close()
+	}
+}
+
+func TestRpcErrorStack(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.SetSendStack(true)
+	s.Register("rpc_error", test_Error)
+	addr := startTestServer(t, s)
+
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	var r func(string) error
+	if err := c.MakeRpc("rpc_error", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	err := r("stack")
+	var s2 tb.StackError
+	if !errors.As(err, &s2) || len(s2.StackTrace()) == 0 {
+		t.Fatal(err)
+	} else if err.Error() != "broken" {
+		t.Fatal(err)
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	if CodeOf(nil) != CodeOK {
+		t.Fatal("nil must be ok")
+	} else if CodeOf(context.Canceled) != CodeCanceled {
+		t.Fatal("canceled")
+	} else if CodeOf(errors.New("dial")) != CodeUnavailable {
+		t.Fatal("unavailable")
+	} else if !errors.Is(NewError(CodeDeadlineExceeded, "timeout"), context.DeadlineExceeded) {
+		t.Fatal("deadline")
+	} else if CodeNotFound.String() != "NotFound" {
+		t.Fatal(CodeNotFound.String())
+	}
+}
+
+func TestRpcErrorCompare(t *testing.T) {
+	var err error = NewError(CodeNotFound, "missing").WithDetail("key", "a")
+
+	// RpcError is comparable, == doesn't panic
+	if err == ErrNotFound || err == (RpcError{Message: "missing"}) {
+		t.Fatal("must not be equal")
+	}
+
+	err = RpcError{Code: CodeNotFound}
+	if err != ErrNotFound {
+		t.Fatal("must be equal")
+	}
+}
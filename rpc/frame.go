@@ -0,0 +1,108 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultMaxMessageSize is the default max size of a message read or written,
+// a peer can't make the other side allocate more than it.
+const DefaultMaxMessageSize = 64 << 20
+
+// DefaultReadTimeout is the default max time reading a frame after its first byte,
+// so a peer can't hold a partly sent frame forever.
+const DefaultReadTimeout = time.Minute
+
+// frames larger than it are read in chunks, see readData
+const frameChunkSize = 64 << 10
+
+// FrameError is returned when an oversize or malformed frame is read,
+// the connection is closed since the frames after it can't be trusted.
+type FrameError struct {
+	Seq    uint32
+	Type   byte
+	Length int
+	Reason string
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("rpc invalid frame seq %d type %d length %d: %s", e.Seq, e.Type, e.Length, e.Reason)
+}
+
+// errIdle is returned by ReadMessage if no frame arrives in idle timeout,
+// the connection is not closed so that a busy one can go on reading.
+var errIdle = errors.New("rpc connection idle timeout")
+
+// connLimits bounds what a connection reads and writes, 0 means no limit.
+type connLimits struct {
+	maxRead  int
+	maxWrite int
+
+	// max time waiting for the next frame
+	idleTimeout time.Duration
+	// max time reading a frame after its first byte
+	readTimeout time.Duration
+	// max time the server waits for the client handshake
+	handshakeTimeout time.Duration
+}
+
+var defaultLimits = connLimits{
+	maxRead:          DefaultMaxMessageSize,
+	maxWrite:         DefaultMaxMessageSize,
+	readTimeout:      DefaultReadTimeout,
+	handshakeTimeout: handshakeTimeout,
+}
+
+// checkWrite returns an error if data is too large to write.
+func (c *conn) checkWrite(data []byte) error {
+	if c.maxWrite > 0 && len(data) > c.maxWrite {
+		return NewError(CodeResourceExhausted, "rpc message size %d exceeds limit %d", len(data), c.maxWrite)
+	}
+	return nil
+}
+
+func maxSize(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// SetMaxMessageSize sets the max size of a call message read by the server and of
+// a reply or streaming element written, <= 0 means no limit, default DefaultMaxMessageSize.
+// It must be called before Start.
+func (s *Server) SetMaxMessageSize(maxRequest, maxResponse int) {
+	s.limits.maxRead = maxSize(maxRequest)
+	s.limits.maxWrite = maxSize(maxResponse)
+}
+
+// SetTimeouts sets the idle timeout after which a connection without running calls
+// is closed, and the read timeout of a frame, 0 means no timeout. By default there is
+// no idle timeout and the read timeout is DefaultReadTimeout.
+// It must be called before Start.
+func (s *Server) SetTimeouts(idle, read time.Duration) {
+	s.limits.idleTimeout = idle
+	s.limits.readTimeout = read
+}
+
+// SetMaxMessageSize sets the max size of a call message written by the client and of
+// a reply or streaming element read, <= 0 means no limit, default DefaultMaxMessageSize.
+// Only new connections are affected.
+func (c *Client) SetMaxMessageSize(maxRequest, maxResponse int) {
+	c.Lock()
+	c.limits.maxWrite = maxSize(maxRequest)
+	c.limits.maxRead = maxSize(maxResponse)
+	c.Unlock()
+}
+
+// SetTimeouts sets the idle timeout after which a connection without pending calls
+// is closed, and the read timeout of a frame, 0 means no timeout. By default there is
+// no idle timeout and the read timeout is DefaultReadTimeout.
+// Only new connections are affected.
+func (c *Client) SetTimeouts(idle, read time.Duration) {
+	c.Lock()
+	c.limits.idleTimeout = idle
+	c.limits.readTimeout = read
+	c.Unlock()
+}
@@ -0,0 +1,237 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnFrameError(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+
+	w := &conn{co: c1, compressThreshold: 16}
+	r := &conn{co: c2, connLimits: connLimits{maxRead: 1000}}
+
+	// small on the wire, too large decoded
+	go w.WriteMessage(1, msgCall, bytes.Repeat([]byte("a"), 10000))
+
+	var fe *FrameError
+	if _, _, _, err := r.ReadMessage(); !errors.As(err, &fe) {
+		t.Fatal(err)
+	} else if fe.Seq != 1 || fe.Length != 10000 {
+		t.Fatal(fe)
+	}
+
+	c1, c2 = net.Pipe()
+	defer c1.Close()
+	r = &conn{co: c2, connLimits: connLimits{maxRead: 1000}}
+
+	h := make([]byte, headerLen)
+	binary.LittleEndian.PutUint32(h[0:4], 1<<30)
+	go c1.Write(h)
+
+	if _, _, _, err := r.ReadMessage(); !errors.As(err, &fe) || fe.Length != 1<<30 {
+		t.Fatal(err)
+	}
+
+	c1, c2 = net.Pipe()
+	defer c1.Close()
+	r = &conn{co: c2}
+
+	binary.LittleEndian.PutUint32(h[0:4], 0)
+	h[8] = 0x7f
+	go c1.Write(h)
+
+	if _, _, _, err := r.ReadMessage(); !errors.As(err, &fe) || fe.Type != 0x7f {
+		t.Fatal(err)
+	}
+}
+
+func TestConnReadGrows(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+
+	w := &conn{co: c1}
+	r := &conn{co: c2, connLimits: connLimits{maxRead: 1 << 30}}
+
+	// larger than a chunk
+	data := bytes.Repeat([]byte("abc"), frameChunkSize)
+	go w.WriteMessage(1, msgCall, data)
+
+	if _, _, d, err := r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(d, data) {
+		t.Fatal(len(d))
+	}
+
+	// a header claiming 256MiB allocates only for the bytes sent
+	h := make([]byte, headerLen)
+	binary.LittleEndian.PutUint32(h[0:4], 1<<28)
+	go func() {
+		c1.Write(h)
+		c1.Write(make([]byte, 100))
+		c1.Close()
+	}()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, _, _, err := r.ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatal(n)
+	}
+}
+
+func TestMessageSizeLimit(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.SetMaxMessageSize(4096, 4096)
+	s.Register("size", func(a string, n int) (string, error) {
+		return strings.Repeat("a", n), nil
+	})
+	addr := startTestServer(t, s)
+
+	// a huge length prefix closes the connection
+	co, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := make([]byte, headerLen)
+	binary.LittleEndian.PutUint32(h[0:4], 0xffffffff)
+	h[8] = msgHandshake
+	co.Write(h)
+	co.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = co.Read(h); err == nil {
+		t.Fatal("must be closed")
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Fatal(err)
+	}
+	co.Close()
+
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	var r func(string, int) (string, error)
+	if err := c.MakeRpc("size", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, err := r("", 10); err != nil || len(a) != 10 {
+		t.Fatal(a, err)
+	}
+
+	// request too large for server
+	if _, err := r(strings.Repeat("a", 5000), 10); CodeOf(err) != CodeUnavailable {
+		t.Fatal(err)
+	}
+
+	// reply too large for server
+	if _, err := r("", 5000); !errors.Is(err, ErrResourceExhausted) {
+		t.Fatal(err)
+	}
+
+	// request too large for client
+	c.SetMaxMessageSize(1000, 600)
+	c.Close()
+	if _, err := r(strings.Repeat("a", 2000), 10); !errors.Is(err, ErrResourceExhausted) {
+		t.Fatal(err)
+	}
+
+	// reply too large for client
+	var fe *FrameError
+	if _, err := r("", 1000); !errors.As(err, &fe) {
+		t.Fatal(err)
+	}
+
+	if a, err := r("", 10); err != nil || len(a) != 10 {
+		t.Fatal(a, err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.SetTimeouts(100*time.Millisecond, time.Second)
+	s.Register("sleep", func(ms int) error {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return nil
+	})
+	addr := startTestServer(t, s)
+
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	var r func(int) error
+	if err := c.MakeRpc("sleep", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	// a running call keeps the connection
+	if err := r(300); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.Pool.Dials != 1 {
+		t.Fatal(st.Pool)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if st := c.Stats(); st.Pool.Idle+st.Pool.InUse != 0 {
+		t.Fatal("idle connection must be closed", st.Pool)
+	}
+
+	if err := r(0); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.Pool.Dials != 2 {
+		t.Fatal(st.Pool)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.limits.handshakeTimeout = 100 * time.Millisecond
+	s.Register("echo", func(a int) (int, error) { return a, nil })
+	addr := startTestServer(t, s)
+
+	// a peer never sending the handshake is closed
+	co, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer co.Close()
+
+	co.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = co.Read(make([]byte, 1)); err == nil {
+		t.Fatal("must be closed")
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Fatal(err)
+	}
+
+	// a handshaken connection outlives the timeout
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	var r func(int) (int, error)
+	if err := c.MakeRpc("echo", &r); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := r(1); err != nil || a != 1 {
+		t.Fatal(a, err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if a, err := r(2); err != nil || a != 2 {
+		t.Fatal(a, err)
+	}
+	if st := c.Stats(); st.Pool.Dials != 1 {
+		t.Fatal(st.Pool)
+	}
+}
@@ -0,0 +1,214 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Future is the pending result of a call started by Go.
+type Future struct {
+	done chan struct{}
+
+	// results without the final error
+	out []interface{}
+	err error
+}
+
+// Go calls fn with args in a new goroutine and returns its future, fn is a
+// function bound by MakeRpc, or any function whose final result is an error.
+func Go(fn interface{}, args ...interface{}) *Future {
+	f := &Future{done: make(chan struct{})}
+
+	in, err := callArgs(reflect.ValueOf(fn), args)
+	if err != nil {
+		f.finish(nil, err)
+		return f
+	}
+
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				f.finish(nil, NewError(CodeInternal, "future panic: %v", e))
+			}
+		}()
+
+		out := reflect.ValueOf(fn).Call(in)
+
+		var err error
+		if e := out[len(out)-1]; !e.IsNil() {
+			err = e.Interface().(error)
+		}
+
+		res := make([]interface{}, len(out)-1)
+		for i := range res {
+			res[i] = out[i].Interface()
+		}
+		f.finish(res, err)
+	}()
+
+	return f
+}
+
+// FanOut calls every function of fns with args concurrently and returns their
+// futures, fns is a slice of functions of the same type, e.g. the same rpc bound
+// on several clients. If fns is not a slice, a single failed future is returned.
+func FanOut(fns interface{}, args ...interface{}) []*Future {
+	v := reflect.ValueOf(fns)
+	if v.Kind() != reflect.Slice {
+		f := &Future{done: make(chan struct{})}
+		f.finish(nil, NewError(CodeInvalidArgument, "fan out needs a slice of functions, not %T", fns))
+		return []*Future{f}
+	}
+
+	futures := make([]*Future, v.Len())
+	for i := range futures {
+		futures[i] = Go(v.Index(i).Interface(), args...)
+	}
+	return futures
+}
+
+func callArgs(fn reflect.Value, args []interface{}) ([]reflect.Value, error) {
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s is not callable", t)
+	}
+
+	if t.NumOut() == 0 || t.Out(t.NumOut()-1) != errorType {
+		return nil, fmt.Errorf("%s return final output param must be error interface", t)
+	}
+
+	if (t.IsVariadic() && len(args) < t.NumIn()-1) || (!t.IsVariadic() && len(args) != t.NumIn()) {
+		return nil, NewError(CodeInvalidArgument, "%s needs %d args, not %d", t, t.NumIn(), len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var at reflect.Type
+		if t.IsVariadic() && i >= t.NumIn()-1 {
+			at = t.In(t.NumIn() - 1).Elem()
+		} else {
+			at = t.In(i)
+		}
+
+		if arg == nil {
+			in[i] = reflect.Zero(at)
+			continue
+		}
+
+		v := reflect.ValueOf(arg)
+		if v.Type().AssignableTo(at) {
+			in[i] = v
+		} else if v.Type().ConvertibleTo(at) {
+			in[i] = v.Convert(at)
+		} else {
+			return nil, NewError(CodeInvalidArgument, "arg %d of %s must be %s, not %s", i, t, at, v.Type())
+		}
+	}
+	return in, nil
+}
+
+func (f *Future) finish(out []interface{}, err error) {
+	f.out = out
+	f.err = err
+	close(f.done)
+}
+
+// Done returns a channel closed when the call is finished.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for the call and returns its results without the final error, and the error.
+func (f *Future) Wait() ([]interface{}, error) {
+	<-f.done
+	return f.out, f.err
+}
+
+// Err waits for the call and returns its error.
+func (f *Future) Err() error {
+	<-f.done
+	return f.err
+}
+
+// Result waits for the call and stores its results into ptrs in order,
+// a nil ptr skips the result.
+func (f *Future) Result(ptrs ...interface{}) error {
+	<-f.done
+	if f.err != nil {
+		return f.err
+	}
+
+	if len(ptrs) > len(f.out) {
+		return fmt.Errorf("%d results, not %d", len(f.out), len(ptrs))
+	}
+
+	for i, ptr := range ptrs {
+		if ptr == nil || f.out[i] == nil {
+			continue
+		}
+
+		v := reflect.ValueOf(ptr)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return fmt.Errorf("result %d needs a pointer, not %T", i, ptr)
+		}
+
+		r := reflect.ValueOf(f.out[i])
+		if !r.Type().AssignableTo(v.Elem().Type()) {
+			return fmt.Errorf("result %d is %s, not %s", i, r.Type(), v.Elem().Type())
+		}
+		v.Elem().Set(r)
+	}
+	return nil
+}
+
+// First waits for the first future succeeded and returns it, if all failed the
+// last error is returned. ctx error is returned if ctx is done first.
+func First(ctx context.Context, futures ...*Future) (*Future, error) {
+	if len(futures) == 0 {
+		return nil, fmt.Errorf("no future to wait")
+	}
+
+	cases := make([]reflect.SelectCase, len(futures)+1)
+	cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+	for i, f := range futures {
+		cases[i+1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(f.done)}
+	}
+
+	var err error
+	for left := len(futures); left > 0; left-- {
+		i, _, _ := reflect.Select(cases)
+		if i == 0 {
+			return nil, ctx.Err()
+		}
+
+		f := futures[i-1]
+		if f.err == nil {
+			return f, nil
+		}
+		err = f.err
+
+		// a nil channel is never selected
+		cases[i].Chan = reflect.ValueOf((<-chan struct{})(nil))
+	}
+	return nil, err
+}
+
+// All waits for all futures and returns the first error in order if any.
+// ctx error is returned if ctx is done first.
+func All(ctx context.Context, futures ...*Future) error {
+	for _, f := range futures {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for _, f := range futures {
+		if f.err != nil {
+			return f.err
+		}
+	}
+	return nil
+}
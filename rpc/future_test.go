@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func test_Future(id int) (int, string, error) {
+	if id < 0 {
+		return 0, "", errors.New("negative id")
+	}
+	time.Sleep(time.Duration(id) * time.Millisecond)
+	return id * 10, "abc", nil
+}
+
+func TestFuture(t *testing.T) {
+	s := newTestServer()
+	s.Register("future", test_Future)
+
+	c := newTestClient()
+
+	var r func(int) (int, string, error)
+	if err := c.MakeRpc("future", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	f := Go(r, 1)
+	<-f.Done()
+
+	var a int
+	var b string
+	if err := f.Result(&a, &b); err != nil {
+		t.Fatal(err)
+	} else if a != 10 || b != "abc" {
+		t.Fatal(a, b)
+	}
+
+	if out, err := f.Wait(); err != nil || len(out) != 2 || out[0].(int) != 10 {
+		t.Fatal(out, err)
+	}
+
+	if err := Go(r, -1).Err(); err == nil || err.Error() != "negative id" {
+		t.Fatal(err)
+	}
+
+	if err := Go(r, "a").Err(); CodeOf(err) != CodeInvalidArgument {
+		t.Fatal(err)
+	}
+	if err := Go(r).Err(); CodeOf(err) != CodeInvalidArgument {
+		t.Fatal(err)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	s := newTestServer()
+	s.Register("future", test_Future)
+
+	c1 := NewClient("tcp", "127.0.0.1:11182", 1)
+	defer c1.Close()
+	c2 := NewClient("tcp", "127.0.0.1:11182", 1)
+	defer c2.Close()
+
+	fns := make([]func(int) (int, string, error), 2)
+	for i, c := range []*Client{c1, c2} {
+		if err := c.MakeRpc("future", &fns[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+
+	futures := FanOut(fns, 5)
+	if err := All(ctx, futures...); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range futures {
+		var a int
+		if err := f.Result(&a); err != nil || a != 50 {
+			t.Fatal(a, err)
+		}
+	}
+
+	// the fast one wins
+	futures = []*Future{Go(fns[0], 200), Go(fns[1], 1)}
+	if f, err := First(ctx, futures...); err != nil {
+		t.Fatal(err)
+	} else if f != futures[1] {
+		t.Fatal("must be the fast one")
+	}
+
+	// failed ones are skipped
+	futures = []*Future{Go(fns[0], -1), Go(fns[1], 10)}
+	if f, err := First(ctx, futures...); err != nil {
+		t.Fatal(err)
+	} else if f != futures[1] {
+		t.Fatal("must be the succeeded one")
+	}
+
+	if err := All(ctx, futures...); err == nil {
+		t.Fatal("must error")
+	}
+	if _, err := First(ctx, FanOut(fns, -1)...); err == nil {
+		t.Fatal("must error")
+	}
+	if err := All(ctx, FanOut(fns[0], 1)...); CodeOf(err) != CodeInvalidArgument {
+		t.Fatal(err)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := First(tctx, Go(fns[0], 200)); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
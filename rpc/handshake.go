@@ -0,0 +1,153 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// handshake is exchanged as JSON when a connection is established, the client
+// offers what it supports in preference order and the server replies its choice.
+type handshake struct {
+	Codecs       []string `json:"codecs,omitempty"`
+	Compressions []string `json:"compressions,omitempty"`
+
+	// client serves calls from server over the connection
+	Callbacks bool `json:"callbacks,omitempty"`
+
+	Codec       string `json:"codec,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func codecNames(codecs []Codec) []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+func (c *conn) writeHandshake(h *handshake) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(0, msgHandshake, data)
+}
+
+func (c *conn) readHandshake() (*handshake, error) {
+	seq, typ, data, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != msgHandshake {
+		c.Close()
+		return nil, &FrameError{seq, typ, len(data), "not a handshake"}
+	}
+
+	h := new(handshake)
+	if err = json.Unmarshal(data, h); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// clientHandshake negotiates the connection options with the server,
+// compression is offered if compressThreshold > 0.
+func (c *conn) clientHandshake(ctx context.Context, codecs []Codec, compressThreshold int) error {
+	if d, ok := ctx.Deadline(); ok {
+		c.co.SetDeadline(d)
+		defer c.co.SetDeadline(time.Time{})
+	}
+
+	offer := &handshake{Codecs: codecNames(codecs), Callbacks: true}
+	if compressThreshold > 0 {
+		offer.Compressions = []string{snappyCompression}
+	}
+
+	if err := c.writeHandshake(offer); err != nil {
+		return err
+	}
+
+	h, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+
+	if len(h.Error) > 0 {
+		c.Close()
+		return fmt.Errorf("rpc handshake error: %s", h.Error)
+	}
+
+	if c.codec = findCodec(codecs, h.Codec); c.codec == nil {
+		c.Close()
+		return fmt.Errorf("rpc handshake error: invalid codec %s", h.Codec)
+	}
+
+	switch h.Compression {
+	case "":
+	case snappyCompression:
+		c.compressThreshold = compressThreshold
+	default:
+		c.Close()
+		return fmt.Errorf("rpc handshake error: invalid compression %s", h.Compression)
+	}
+
+	return nil
+}
+
+// handshakeTimeout is the default max time the server waits for the handshake of a client.
+const handshakeTimeout = 10 * time.Second
+
+// serverHandshake picks the first codec offered by the client which the server supports,
+// compression is used if the client offers it and compressThreshold > 0.
+func (c *conn) serverHandshake(codecs []Codec, compressThreshold int) error {
+	// the read deadline belongs to ReadMessage, so a stalled client is closed by a timer
+	if c.handshakeTimeout > 0 {
+		t := time.AfterFunc(c.handshakeTimeout, func() { c.Close() })
+		defer t.Stop()
+	}
+
+	h, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range h.Codecs {
+		if c.codec = findCodec(codecs, name); c.codec != nil {
+			break
+		}
+	}
+
+	c.callbacks = h.Callbacks
+
+	if c.codec == nil {
+		c.writeHandshake(&handshake{Error: fmt.Sprintf("no supported codec in %v", h.Codecs)})
+		c.Close()
+		return fmt.Errorf("no supported codec in %v", h.Codecs)
+	}
+
+	reply := &handshake{Codec: c.codec.Name()}
+	if compressThreshold > 0 {
+		for _, name := range h.Compressions {
+			if name == snappyCompression {
+				reply.Compression = name
+				break
+			}
+		}
+	}
+
+	if err = c.writeHandshake(reply); err != nil {
+		return err
+	}
+
+	// start compressing after the handshake reply
+	if len(reply.Compression) > 0 {
+		c.compressThreshold = compressThreshold
+	}
+	return nil
+}
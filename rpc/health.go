@@ -0,0 +1,25 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+)
+
+// PingName is the built-in rpc every server registers, it does nothing
+// but proves the server is alive.
+const PingName = "rpc.Ping"
+
+func ping() error {
+	return nil
+}
+
+var pingType = reflect.TypeOf(ping)
+
+// Ping calls the built-in ping rpc of the server, it is not retried.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.roundTrip(ctx, PingName, nil, pingType)
+	if e, ok := err.(notSentError); ok {
+		err = e.err
+	}
+	return err
+}
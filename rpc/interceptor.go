@@ -0,0 +1,54 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/siddontang/go/log"
+)
+
+// Invoker invokes rpc name with args, it returns the results except the final error.
+type Invoker func(ctx context.Context, name string, args []interface{}) ([]interface{}, error)
+
+// Interceptor intercepts a call to a registered function, it sees the decoded args
+// and may change them, or return without calling invoke to short-circuit the call.
+type Interceptor func(ctx context.Context, name string, args []interface{}, invoke Invoker) ([]interface{}, error)
+
+// chainInterceptors returns an invoker calling interceptors in order before invoke.
+func chainInterceptors(interceptors []Interceptor, invoke Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, next := interceptors[i], invoke
+		invoke = func(ctx context.Context, name string, args []interface{}) ([]interface{}, error) {
+			return ic(ctx, name, args, next)
+		}
+	}
+	return invoke
+}
+
+// RecoverInterceptor converts a panic in the call to an error.
+func RecoverInterceptor(ctx context.Context, name string, args []interface{}, invoke Invoker) (out []interface{}, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			out = nil
+			err = NewError(CodeInternal, "rpc %s panic: %v", name, e)
+		}
+	}()
+
+	return invoke(ctx, name, args)
+}
+
+// LogInterceptor logs every call with its duration, a failed call is logged with error level.
+func LogInterceptor(l *log.Logger) Interceptor {
+	return func(ctx context.Context, name string, args []interface{}, invoke Invoker) ([]interface{}, error) {
+		t := time.Now()
+
+		out, err := invoke(ctx, name, args)
+
+		if err != nil {
+			l.Errorf("rpc %s error %s, %v", name, err.Error(), time.Since(t))
+		} else {
+			l.Debugf("rpc %s ok, %v", name, time.Since(t))
+		}
+		return out, err
+	}
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func test_Panic(id int) (int, error) {
+	panic("test panic")
+}
+
+func TestServerInterceptor(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.Register("rpc1", test_Rpc1)
+	s.Register("panic", test_Panic)
+
+	var calls int32
+	s.Use(RecoverInterceptor, func(ctx context.Context, name string, args []interface{}, invoke Invoker) ([]interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+
+		if MetadataFromContext(ctx)["token"] != "abc" {
+			return nil, errors.New("unauthenticated")
+		}
+
+		out, err := invoke(ctx, name, args)
+		if err == nil && name == "rpc1" {
+			out[1] = "intercepted"
+		}
+		return out, err
+	})
+
+	addr := startTestServer(t, s)
+
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	var r func(context.Context, int) (int, string, error)
+	if err := c.MakeRpc("rpc1", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	var e error
+	for i := 0; i < 10; i++ {
+		if _, _, e = r(context.Background(), 1); e != nil && e.Error() == "unauthenticated" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if e == nil || e.Error() != "unauthenticated" {
+		t.Fatal(e)
+	}
+
+	ctx := WithMetadata(context.Background(), Metadata{"token": "abc"})
+
+	a, b, e := r(ctx, 1)
+	if e != nil {
+		t.Fatal(e)
+	} else if a != 10 || b != "intercepted" {
+		t.Fatal(a, b)
+	}
+
+	var p func(context.Context, int) (int, error)
+	if err := c.MakeRpc("panic", &p); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, e = p(ctx, 1); e == nil {
+		t.Fatal("must error")
+	}
+
+	// connection is still usable after panic
+	if _, _, e = r(ctx, 1); e != nil {
+		t.Fatal(e)
+	}
+
+	if n := atomic.LoadInt32(&calls); n < 4 {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"encoding/json"
+)
+
+// jsonCodec encodes messages as JSON, so peers not written in Go can use it.
+type jsonCodec struct {
+}
+
+type jsonMessage struct {
+	Name     string            `json:"name"`
+	Args     []json.RawMessage `json:"args"`
+	Timeout  int64             `json:"timeout,omitempty"`
+	Metadata Metadata          `json:"metadata,omitempty"`
+	Error    *RpcError         `json:"error,omitempty"`
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Encode(m *Message) ([]byte, error) {
+	jm := jsonMessage{Name: m.Name, Timeout: m.Timeout, Metadata: m.Metadata, Error: m.Error}
+	jm.Args = make([]json.RawMessage, len(m.Args))
+
+	for i, arg := range m.Args {
+		buf, err := json.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		jm.Args[i] = buf
+	}
+
+	return json.Marshal(jm)
+}
+
+func (jsonCodec) Decode(data []byte, m *Message, types TypesFunc) error {
+	var jm jsonMessage
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return err
+	}
+
+	args, err := decodeArgs(len(jm.Args), types(jm.Name), func(i int, v interface{}) error {
+		return json.Unmarshal(jm.Args[i], v)
+	})
+	m.Name = jm.Name
+	if err != nil {
+		return err
+	}
+
+	m.Args = args
+	m.Timeout = jm.Timeout
+	m.Metadata = jm.Metadata
+	m.Error = jm.Error
+	return nil
+}
@@ -0,0 +1,245 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	jsonrpcInternalError  = -32603
+	jsonrpcServerError    = -32000
+)
+
+const jsonrpcVersion = "2.0"
+
+var jsonNull = json.RawMessage("null")
+
+type jsonrpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	// absent for a notification, which has no response
+	ID json.RawMessage `json:"id,omitempty"`
+}
+
+type jsonrpcErrorData struct {
+	Code    string            `json:"code"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Data    *jsonrpcErrorData `json:"data,omitempty"`
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func newJSONRPCError(id json.RawMessage, code int, msg string) *jsonrpcResponse {
+	if len(id) == 0 {
+		id = jsonNull
+	}
+	return &jsonrpcResponse{Version: jsonrpcVersion, Error: &jsonrpcError{Code: code, Message: msg}, ID: id}
+}
+
+// jsonrpcErrorOf maps the code of err to a JSON-RPC error code, the code name
+// and details are kept in error data.
+func jsonrpcErrorOf(err error) *jsonrpcError {
+	e := toRpcError(err, false)
+
+	code := jsonrpcServerError
+	switch e.Code {
+	case CodeUnimplemented:
+		code = jsonrpcMethodNotFound
+	case CodeInvalidArgument:
+		code = jsonrpcInvalidParams
+	case CodeInternal:
+		code = jsonrpcInternalError
+	}
+
+	return &jsonrpcError{Code: code, Message: e.Message, Data: &jsonrpcErrorData{Code: e.Code.String(), Details: e.details()}}
+}
+
+type jsonrpcHandler struct {
+	s *Server
+}
+
+// JSONRPCHandler returns an http handler serving the registered functions as
+// JSON-RPC 2.0 over POST, single and batch requests are supported.
+//
+// Params must be an array in the order of function params, the result is the
+// single result of the function, an array for several results, or null for none.
+// Functions with streaming params or results are not served.
+func (s *Server) JSONRPCHandler() http.Handler {
+	return jsonrpcHandler{s}
+}
+
+func (h jsonrpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "JSON-RPC needs POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.s.limits.maxRead > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(h.s.limits.maxRead))
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var resp interface{}
+
+	if !json.Valid(data) {
+		resp = newJSONRPCError(nil, jsonrpcParseError, "parse error")
+	} else if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		var reqs []json.RawMessage
+		json.Unmarshal(data, &reqs)
+
+		if len(reqs) == 0 {
+			resp = newJSONRPCError(nil, jsonrpcInvalidRequest, "empty batch")
+		} else if resps := h.serveBatch(r.Context(), reqs); len(resps) > 0 {
+			resp = resps
+		}
+	} else if res := h.serve(r.Context(), data); res != nil {
+		resp = res
+	}
+
+	if resp == nil {
+		// notifications only
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveBatch serves requests concurrently, responses are in request order
+// without those of notifications.
+func (h jsonrpcHandler) serveBatch(ctx context.Context, reqs []json.RawMessage) []*jsonrpcResponse {
+	resps := make([]*jsonrpcResponse, len(reqs))
+
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resps[i] = h.serve(ctx, reqs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	n := 0
+	for _, resp := range resps {
+		if resp != nil {
+			resps[n] = resp
+			n++
+		}
+	}
+	return resps[:n]
+}
+
+// serve serves a request, nil is returned for a notification.
+func (h jsonrpcHandler) serve(ctx context.Context, data []byte) *jsonrpcResponse {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return newJSONRPCError(nil, jsonrpcInvalidRequest, "invalid request")
+	} else if req.Version != jsonrpcVersion || len(req.Method) == 0 {
+		return newJSONRPCError(req.ID, jsonrpcInvalidRequest, "invalid request")
+	}
+
+	start := time.Now()
+
+	result, err := h.call(ctx, &req)
+
+	resp := &jsonrpcResponse{Version: jsonrpcVersion, ID: req.ID}
+	if err == nil {
+		if resp.Result, err = json.Marshal(result); err != nil {
+			err = NewError(CodeInternal, "rpc %s marshal result error %v", req.Method, err)
+		}
+	}
+	if err != nil {
+		resp.Result = nil
+		resp.Error = jsonrpcErrorOf(err)
+	}
+
+	name := req.Method
+	if h.s.funcType(name) == nil {
+		name = unregisteredMethod
+	}
+	h.s.stats.record(name, len(data), len(resp.Result), time.Since(start), err)
+
+	if len(req.ID) == 0 {
+		return nil
+	}
+	return resp
+}
+
+func (h jsonrpcHandler) call(ctx context.Context, req *jsonrpcRequest) (interface{}, error) {
+	s := h.s
+	name := req.Method
+
+	if s.isShutdown() {
+		return nil, errShutdown
+	}
+
+	ft := s.funcType(name)
+	if ft == nil {
+		return nil, NewError(CodeUnimplemented, "rpc %s not registered", name)
+	} else if streamIndex(paramTypes(ft)) >= 0 || streamIndex(resultTypes(ft)) >= 0 {
+		return nil, NewError(CodeUnimplemented, "rpc %s streams, not served over http", name)
+	}
+
+	var params []json.RawMessage
+	if len(req.Params) > 0 && !bytes.Equal(req.Params, jsonNull) {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, NewError(CodeInvalidArgument, "rpc %s params must be an array", name)
+		}
+	}
+
+	args, err := decodeArgs(len(params), argTypes(ft), func(i int, v interface{}) error {
+		return json.Unmarshal(params[i], v)
+	})
+	if err != nil {
+		return nil, NewError(CodeInvalidArgument, "rpc %s invalid params: %v", name, err)
+	}
+
+	release, err := s.acquire(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	out, err := s.handle(ctx, name, args)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(out) {
+	case 0:
+		return nil, nil
+	case 1:
+		return out[0], nil
+	default:
+		return out, nil
+	}
+}
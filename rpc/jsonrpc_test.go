@@ -0,0 +1,98 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testJSONRPCArg struct {
+	A int    `json:"a"`
+	B string `json:"b"`
+}
+
+func jsonrpcPost(t *testing.T, h http.Handler, body string) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/rpc", strings.NewReader(body)))
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func TestJSONRPC(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.Register("add", func(a, b int) (int, error) {
+		return a + b, nil
+	})
+	s.Register("split", func(ctx context.Context, a *testJSONRPCArg) (int, string, error) {
+		if a == nil {
+			return 0, "", NewError(CodeNotFound, "no arg").WithDetail("key", "a")
+		}
+		return a.A, a.B, nil
+	})
+	s.Register("stream", func() (<-chan int, error) {
+		return nil, nil
+	})
+
+	h := s.JSONRPCHandler()
+
+	tests := []struct {
+		body string
+		resp string
+	}{
+		{`{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"split","params":[{"a":1,"b":"x"}],"id":"s"}`,
+			`{"jsonrpc":"2.0","result":[1,"x"],"id":"s"}`},
+		{`{"jsonrpc":"2.0","method":"split","params":[null],"id":2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"no arg","data":{"code":"NotFound","details":{"key":"a"}}},"id":2}`},
+		{`{"jsonrpc":"2.0","method":"missing","id":3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"rpc missing not registered","data":{"code":"Unimplemented"}},"id":3}`},
+		{`{"jsonrpc":"2.0","method":"add","params":[1],"id":4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"rpc add needs 2 args, not 1","data":{"code":"InvalidArgument"}},"id":4}`},
+		{`{"jsonrpc":"2.0","method":"add","params":{"a":1},"id":5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"rpc add params must be an array","data":{"code":"InvalidArgument"}},"id":5}`},
+		{`{"jsonrpc":"2.0","method":"stream","id":6}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"rpc stream streams, not served over http","data":{"code":"Unimplemented"}},"id":6}`},
+		{`{"method":"add","id":7}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":7}`},
+		{`{"jsonrpc":"2.0","method"`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error"},"id":null}`},
+		{`[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`},
+		{`[{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1},{"jsonrpc":"2.0","method":"add","params":[3,4]},1,{"jsonrpc":"2.0","method":"add","params":[5,6],"id":2}]`,
+			`[{"jsonrpc":"2.0","result":3,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null},{"jsonrpc":"2.0","result":11,"id":2}]`},
+	}
+
+	for _, test := range tests {
+		code, resp := jsonrpcPost(t, h, test.body)
+		if code != http.StatusOK || resp != test.resp {
+			t.Fatalf("%s: %d %s", test.body, code, resp)
+		}
+	}
+
+	// notifications have no response
+	if code, resp := jsonrpcPost(t, h, `{"jsonrpc":"2.0","method":"add","params":[1,2]}`); code != http.StatusNoContent || resp != "" {
+		t.Fatal(code, resp)
+	}
+	if code, _ := jsonrpcPost(t, h, `[{"jsonrpc":"2.0","method":"add","params":[1,2]}]`); code != http.StatusNoContent {
+		t.Fatal(code)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/rpc", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatal(w.Code)
+	}
+
+	if m := s.Stats().Methods["add"]; m.Calls != 8 || m.Errors != 2 {
+		t.Fatal(m)
+	}
+
+	var resp jsonrpcResponse
+	_, body := jsonrpcPost(t, h, `{"jsonrpc":"2.0","method":"rpc.Ping","id":1}`)
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || resp.Error != nil {
+		t.Fatal(body, err)
+	}
+}
@@ -0,0 +1,104 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/siddontang/go/sync2"
+)
+
+// limiter bounds the running calls, at most queue calls wait for a slot
+// and the others are refused at once.
+type limiter struct {
+	sem     *sync2.Semaphore
+	queue   int64
+	waiting sync2.AtomicInt64
+}
+
+func newLimiter(max, queue int) *limiter {
+	if max <= 0 {
+		return nil
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &limiter{sem: sync2.NewSemaphore(max), queue: int64(queue)}
+}
+
+func (l *limiter) acquire(ctx context.Context, name string) error {
+	if l.sem.TryAcquire() {
+		return nil
+	}
+
+	if l.waiting.Add(1) > l.queue {
+		l.waiting.Add(-1)
+		return NewError(CodeResourceExhausted, "rpc %s is overloaded", name)
+	}
+
+	ok := l.sem.AcquireContext(ctx)
+	l.waiting.Add(-1)
+	if !ok {
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (l *limiter) release() {
+	l.sem.Release()
+}
+
+// SetMaxConcurrency limits the calls running at the same time to max, at most queue
+// calls wait for a slot and the others fail at once with CodeResourceExhausted.
+// max <= 0 means no limit. Built-in rpcs like Ping are not limited.
+func (s *Server) SetMaxConcurrency(max, queue int) {
+	s.Lock()
+	s.limiter = newLimiter(max, queue)
+	s.Unlock()
+}
+
+// SetMethodConcurrency limits the calls of name like SetMaxConcurrency,
+// a call must pass both its method limit and the server limit.
+func (s *Server) SetMethodConcurrency(name string, max, queue int) {
+	s.Lock()
+	if l := newLimiter(max, queue); l != nil {
+		s.limiters[name] = l
+	} else {
+		delete(s.limiters, name)
+	}
+	s.Unlock()
+}
+
+// acquire waits for the limits of name, the returned func releases them.
+func (s *Server) acquire(ctx context.Context, name string) (func(), error) {
+	if name == PingName || name == DescribeName {
+		return func() {}, nil
+	}
+
+	s.Lock()
+	global, method := s.limiter, s.limiters[name]
+	s.Unlock()
+
+	// wait on the method first, so a slow method doesn't hold server slots while queued
+	if method != nil {
+		if err := method.acquire(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if global != nil {
+		if err := global.acquire(ctx, name); err != nil {
+			if method != nil {
+				method.release()
+			}
+			return nil, err
+		}
+	}
+
+	return func() {
+		if global != nil {
+			global.release()
+		}
+		if method != nil {
+			method.release()
+		}
+	}, nil
+}
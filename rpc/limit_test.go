@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestServerConcurrencyLimit(t *testing.T) {
+	block := make(chan struct{})
+
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.Register("block", func() error {
+		<-block
+		return nil
+	})
+	s.Register("echo", func(a int) (int, error) {
+		return a, nil
+	})
+	s.SetMethodConcurrency("block", 1, 1)
+	addr := startTestServer(t, s)
+
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	var b func() error
+	if err := c.MakeRpc("block", &b); err != nil {
+		t.Fatal(err)
+	}
+	var echo func(int) (int, error)
+	if err := c.MakeRpc("echo", &echo); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- b()
+		}()
+	}
+
+	// one runs and one queues, the next is refused at once
+	time.Sleep(100 * time.Millisecond)
+	if err := b(); !errors.Is(err, ErrResourceExhausted) {
+		t.Fatal(err)
+	}
+
+	// other methods are not limited
+	if a, err := echo(1); err != nil || a != 1 {
+		t.Fatal(a, err)
+	}
+
+	close(block)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// global limit
+	s.SetMaxConcurrency(1, 0)
+	block2 := make(chan struct{})
+	s.Register("block2", func() error {
+		<-block2
+		return nil
+	})
+	var b2 func() error
+	if err := c.MakeRpc("block2", &b2); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		errs <- b2()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := echo(1); CodeOf(err) != CodeResourceExhausted {
+		t.Fatal(err)
+	}
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	close(block2)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := echo(1); err != nil {
+		t.Fatal(err)
+	}
+}
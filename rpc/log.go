@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"github.com/siddontang/go/log"
+)
+
+func newLogger(w io.Writer) *log.Logger {
+	h, _ := log.NewStreamHandler(w)
+	return log.NewDefault(h)
+}
+
+// defaultLogger logs to stderr with info level, closed connections are logged
+// with debug level so they are not shown.
+var defaultLogger = newLogger(os.Stderr)
+
+var discardLogger = newLogger(ioutil.Discard)
+
+// SetLogger sets the logger of connection and call failures, nil discards them.
+// It must be called before Start.
+func (s *Server) SetLogger(l *log.Logger) {
+	if l == nil {
+		l = discardLogger
+	}
+	s.logger = l
+}
+
+// SetLogger sets the logger of connection failures, and of failures of the calls
+// from server, see Register. nil discards them.
+func (c *Client) SetLogger(l *log.Logger) {
+	if l == nil {
+		l = discardLogger
+	}
+
+	c.Lock()
+	c.logger = l
+	c.Unlock()
+
+	c.callbacks.SetLogger(l)
+}
+
+func (c *Client) getLogger() *log.Logger {
+	c.Lock()
+	defer c.Unlock()
+	return c.logger
+}
+
+// isClosed returns whether err is caused by a connection closed normally.
+func isClosed(err error) bool {
+	return err == io.EOF || errors.Is(err, net.ErrClosed) || err == errClientClosed || err == errPeerClosed || err == errIdle
+}
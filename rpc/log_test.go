@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/siddontang/go/log"
+)
+
+type syncBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.String()
+}
+
+func TestServerPanic(t *testing.T) {
+	var buf syncBuffer
+
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.SetLogger(newLogger(&buf))
+	s.Register("panic", func(a int) (int, error) {
+		if a < 0 {
+			panic("negative")
+		}
+		return a, nil
+	})
+	addr := startTestServer(t, s)
+
+	c := NewClient("tcp", addr, 1)
+	c.SetLogger(nil)
+	defer c.Close()
+
+	var r func(int) (int, error)
+	if err := c.MakeRpc("panic", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r(-1); !errors.Is(err, ErrInternal) {
+		t.Fatal(err)
+	}
+
+	// the connection is kept
+	if a, err := r(1); err != nil || a != 1 {
+		t.Fatal(a, err)
+	} else if st := c.Stats(); st.Pool.Dials != 1 {
+		t.Fatal(st.Pool)
+	}
+
+	if out := buf.String(); !strings.Contains(out, "[Error]") || !strings.Contains(out, "rpc panic panic: negative") {
+		t.Fatal(out)
+	}
+}
+
+func TestStreamPanic(t *testing.T) {
+	s := newTestServer()
+	s.Register("stream_panic", func() (func() (int, bool, error), error) {
+		n := 0
+		return func() (int, bool, error) {
+			if n++; n > 2 {
+				panic("iterator")
+			}
+			return n, true, nil
+		}, nil
+	})
+
+	c := newTestClient()
+
+	var r func(context.Context) (<-chan int, error)
+	if err := c.MakeRpc("stream_panic", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := r(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != 2 {
+		t.Fatal(n)
+	}
+}
+
+func TestSetLoggerLevel(t *testing.T) {
+	var buf syncBuffer
+
+	l := newLogger(&buf)
+	l.SetLevel(log.LevelError)
+
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.SetLogger(l)
+	s.logger.Warnf("filtered")
+	if buf.String() != "" {
+		t.Fatal(buf.String())
+	}
+}
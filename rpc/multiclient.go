@@ -0,0 +1,386 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/siddontang/go/sync2"
+)
+
+// Balancer decides which endpoint a call of MultiClient goes to.
+type Balancer int
+
+const (
+	RoundRobin Balancer = iota
+	// the endpoint with the least outstanding calls
+	LeastRequests
+)
+
+// Resolver returns the addresses of endpoints, it is called periodically
+// so endpoints can be changed.
+type Resolver func() ([]string, error)
+
+var errNoEndpoint = errors.New("rpc no endpoint")
+
+const defaultCheckInterval = 5 * time.Second
+
+type endpoint struct {
+	addr   string
+	client *Client
+
+	outstanding sync2.AtomicInt64
+
+	// guarded by MultiClient
+	failures     int
+	ejectedUntil time.Time
+}
+
+// MultiClient spreads calls over multiple endpoints, each one uses a Client.
+//
+// An endpoint is ejected after maxFailures consecutive transport failures,
+// either of calls or of periodic health checks by Ping, and re-admitted
+// after ejectTime or once a health check succeeds. If all endpoints are
+// ejected, calls are spread over all of them.
+type MultiClient struct {
+	sync.Mutex
+
+	network  string
+	maxConns int
+	resolver Resolver
+	balancer Balancer
+
+	endpoints []*endpoint
+	next      int
+
+	checkInterval time.Duration
+	maxFailures   int
+	ejectTime     time.Duration
+
+	configure func(c *Client)
+	// changed by SetClientConfig, so resolve knows its configure is stale
+	configVersion int
+
+	interceptors []Interceptor
+
+	retrier
+
+	quit      chan struct{}
+	resetTick chan struct{}
+	closed    bool
+}
+
+// NewMultiClient returns a client for fixed addrs, maxConns is for every endpoint.
+func NewMultiClient(network string, addrs []string, maxConns int) *MultiClient {
+	return NewMultiClientWithResolver(network, func() ([]string, error) {
+		return addrs, nil
+	}, maxConns)
+}
+
+// NewMultiClientWithResolver returns a client for the addresses resolver returns,
+// maxConns is for every endpoint.
+func NewMultiClientWithResolver(network string, resolver Resolver, maxConns int) *MultiClient {
+	m := new(MultiClient)
+
+	m.network = network
+	m.maxConns = maxConns
+	m.resolver = resolver
+	m.balancer = RoundRobin
+
+	m.checkInterval = defaultCheckInterval
+	m.maxFailures = 3
+	m.ejectTime = 30 * time.Second
+
+	m.retrier.init()
+
+	m.quit = make(chan struct{})
+	m.resetTick = make(chan struct{}, 1)
+
+	m.resolve()
+
+	go m.run()
+
+	return m
+}
+
+func (m *MultiClient) SetBalancer(b Balancer) {
+	m.Lock()
+	m.balancer = b
+	m.Unlock()
+}
+
+// SetHealthCheck sets the health check interval, consecutive failures to eject
+// an endpoint and how long it is ejected. An interval <= 0 means the default 5s.
+func (m *MultiClient) SetHealthCheck(interval time.Duration, maxFailures int, ejectTime time.Duration) {
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+
+	m.Lock()
+	m.checkInterval = interval
+	m.maxFailures = maxFailures
+	m.ejectTime = ejectTime
+	m.Unlock()
+
+	select {
+	case m.resetTick <- struct{}{}:
+	default:
+	}
+}
+
+// SetClientConfig sets a function to configure the Client of every endpoint,
+// e.g. SetCodecs and SetTLSConfig. f is called without the lock of m held,
+// so it can use m.
+func (m *MultiClient) SetClientConfig(f func(c *Client)) {
+	m.Lock()
+	m.configure = f
+	m.configVersion++
+	endpoints := m.endpoints
+	m.Unlock()
+
+	for _, ep := range endpoints {
+		f(ep.client)
+	}
+}
+
+// Use appends interceptors, see Client.Use.
+func (m *MultiClient) Use(interceptors ...Interceptor) {
+	m.Lock()
+	m.interceptors = append(m.interceptors, interceptors...)
+	m.Unlock()
+}
+
+// MakeRpc binds function pointer fptr to rpc name, see Client.MakeRpc.
+func (m *MultiClient) MakeRpc(rpcName string, fptr interface{}) error {
+	return makeRpc(m, rpcName, fptr)
+}
+
+// HealthyEndpoints returns the addresses of the endpoints not ejected.
+func (m *MultiClient) HealthyEndpoints() []string {
+	now := time.Now()
+
+	m.Lock()
+	defer m.Unlock()
+
+	addrs := make([]string, 0, len(m.endpoints))
+	for _, ep := range m.endpoints {
+		if !now.Before(ep.ejectedUntil) {
+			addrs = append(addrs, ep.addr)
+		}
+	}
+	return addrs
+}
+
+func (m *MultiClient) Close() error {
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		return nil
+	}
+	m.closed = true
+	endpoints := m.endpoints
+	m.endpoints = nil
+	m.Unlock()
+
+	close(m.quit)
+
+	for _, ep := range endpoints {
+		ep.client.Close()
+	}
+	return nil
+}
+
+func (m *MultiClient) callInterceptors() []Interceptor {
+	m.Lock()
+	defer m.Unlock()
+	return m.interceptors
+}
+
+func (m *MultiClient) invoke(ctx context.Context, name string, args []interface{}, ft reflect.Type) ([]interface{}, error) {
+	return m.retry(ctx, name, ft, func() ([]interface{}, error) {
+		ep, err := m.pick()
+		if err != nil {
+			return nil, notSentError{err}
+		}
+
+		ep.outstanding.Add(1)
+		out, err := ep.client.roundTrip(ctx, name, args, ft)
+		ep.outstanding.Add(-1)
+
+		if ctx.Err() == nil {
+			m.report(ep, err)
+		}
+
+		return out, err
+	})
+}
+
+func (m *MultiClient) pick() (*endpoint, error) {
+	now := time.Now()
+
+	m.Lock()
+	defer m.Unlock()
+
+	avail := make([]*endpoint, 0, len(m.endpoints))
+	for _, ep := range m.endpoints {
+		if !now.Before(ep.ejectedUntil) {
+			avail = append(avail, ep)
+		}
+	}
+
+	if len(avail) == 0 {
+		avail = m.endpoints
+	}
+
+	if len(avail) == 0 {
+		return nil, errNoEndpoint
+	}
+
+	m.next++
+	start := m.next % len(avail)
+
+	if m.balancer != LeastRequests {
+		return avail[start], nil
+	}
+
+	// begin from the round robin one so ties are spread
+	ep := avail[start]
+	for i := 1; i < len(avail); i++ {
+		e := avail[(start+i)%len(avail)]
+		if e.outstanding.Get() < ep.outstanding.Get() {
+			ep = e
+		}
+	}
+	return ep, nil
+}
+
+// report records the result of a call or a health check to ep.
+func (m *MultiClient) report(ep *endpoint, err error) {
+	m.Lock()
+	if err == nil || !IsTransportError(err) {
+		ep.failures = 0
+		ep.ejectedUntil = time.Time{}
+	} else {
+		ep.failures++
+		if ep.failures >= m.maxFailures {
+			ep.ejectedUntil = time.Now().Add(m.ejectTime)
+		}
+	}
+	m.Unlock()
+}
+
+func (m *MultiClient) run() {
+	for {
+		m.Lock()
+		interval := m.checkInterval
+		m.Unlock()
+
+		t := time.NewTimer(interval)
+
+		select {
+		case <-m.quit:
+			t.Stop()
+			return
+		case <-m.resetTick:
+			t.Stop()
+			continue
+		case <-t.C:
+		}
+
+		m.resolve()
+		m.check(interval)
+	}
+}
+
+// resolve updates endpoints by resolver, new clients are configured without the lock held.
+func (m *MultiClient) resolve() {
+	addrs, err := m.resolver()
+	if err != nil {
+		return
+	}
+
+	m.Lock()
+	old := make(map[string]*endpoint, len(m.endpoints))
+	for _, ep := range m.endpoints {
+		old[ep.addr] = ep
+	}
+	configure, version := m.configure, m.configVersion
+	m.Unlock()
+
+	added := make(map[string]*endpoint)
+	for _, addr := range addrs {
+		if _, ok := old[addr]; ok {
+			continue
+		}
+
+		ep := &endpoint{addr: addr, client: NewClient(m.network, addr, m.maxConns)}
+		if configure != nil {
+			configure(ep.client)
+		}
+		added[addr] = ep
+	}
+
+	// endpoints are only changed here, which is not called concurrently
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		for _, ep := range added {
+			ep.client.Close()
+		}
+		return
+	}
+
+	endpoints := make([]*endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		ep, ok := old[addr]
+		if ok {
+			delete(old, addr)
+		} else {
+			ep = added[addr]
+		}
+		endpoints = append(endpoints, ep)
+	}
+	m.endpoints = endpoints
+
+	if m.configVersion == version {
+		configure = nil
+	} else {
+		// changed while configuring
+		configure = m.configure
+	}
+	m.Unlock()
+
+	if configure != nil {
+		for _, ep := range added {
+			configure(ep.client)
+		}
+	}
+
+	for _, ep := range old {
+		ep.client.Close()
+	}
+}
+
+// check pings all endpoints concurrently.
+func (m *MultiClient) check(timeout time.Duration) {
+	m.Lock()
+	endpoints := m.endpoints
+	m.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func(ep *endpoint) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			err := ep.client.Ping(ctx)
+			cancel()
+
+			m.report(ep, err)
+		}(ep)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,154 @@
+package rpc
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/siddontang/go/sync2"
+)
+
+func newTestMultiServer(t *testing.T, addr string) *Server {
+	s := NewServer("tcp", addr)
+	s.Register("addr", func() (string, error) {
+		return addr, nil
+	})
+
+	go s.Start()
+
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	var r func() (string, error)
+	c.MakeRpc("addr", &r)
+
+	for i := 0; i < 10; i++ {
+		if _, e := r(); e == nil {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("server not started")
+	return nil
+}
+
+func TestMultiClient(t *testing.T) {
+	addrs := []string{"127.0.0.1:11187", "127.0.0.1:11188"}
+
+	s1 := newTestMultiServer(t, addrs[0])
+	defer s1.Stop()
+
+	s2 := newTestMultiServer(t, addrs[1])
+	defer s2.Stop()
+
+	m := NewMultiClient("tcp", addrs, 1)
+	defer m.Close()
+
+	m.SetHealthCheck(time.Hour, 1, time.Hour)
+	m.SetIdempotent("addr")
+
+	var r func() (string, error)
+	if err := m.MakeRpc("addr", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		a, e := r()
+		if e != nil {
+			t.Fatal(e)
+		}
+		seen[a]++
+	}
+
+	if seen[addrs[0]] != 5 || seen[addrs[1]] != 5 {
+		t.Fatal(seen)
+	}
+
+	s2.Stop()
+
+	for i := 0; i < 10; i++ {
+		if a, e := r(); e != nil {
+			t.Fatal(e)
+		} else if i > 2 && a != addrs[0] {
+			t.Fatal(a)
+		}
+	}
+
+	if h := m.HealthyEndpoints(); len(h) != 1 || h[0] != addrs[0] {
+		t.Fatal(h)
+	}
+
+	m.SetBalancer(LeastRequests)
+	if a, e := r(); e != nil {
+		t.Fatal(e)
+	} else if a != addrs[0] {
+		t.Fatal(a)
+	}
+}
+
+func TestMultiClientHealthCheck(t *testing.T) {
+	addrs := []string{"127.0.0.1:11189", "127.0.0.1:11190"}
+
+	s1 := newTestMultiServer(t, addrs[0])
+	defer s1.Stop()
+
+	m := NewMultiClientWithResolver("tcp", func() ([]string, error) {
+		return addrs, nil
+	}, 1)
+	defer m.Close()
+
+	m.SetHealthCheck(20*time.Millisecond, 1, time.Hour)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if h := m.HealthyEndpoints(); len(h) != 1 || h[0] != addrs[0] {
+		t.Fatal(h)
+	}
+
+	s2 := newTestMultiServer(t, addrs[1])
+	defer s2.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if h := m.HealthyEndpoints(); len(h) != 2 {
+		t.Fatal(h)
+	}
+}
+
+func TestMultiClientConfig(t *testing.T) {
+	var resolved sync2.AtomicInt64
+	m := NewMultiClientWithResolver("tcp", func() ([]string, error) {
+		// a new endpoint every time
+		n := resolved.Add(1)
+		return []string{"127.0.0.1:" + strconv.Itoa(int(21000+n))}, nil
+	}, 1)
+	defer m.Close()
+
+	// the config can use m without deadlock
+	configured := make(chan struct{}, 16)
+	m.SetClientConfig(func(c *Client) {
+		m.SetBalancer(LeastRequests)
+		configured <- struct{}{}
+	})
+
+	m.SetHealthCheck(20*time.Millisecond, 1, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-configured:
+		case <-time.After(2 * time.Second):
+			t.Fatal("not configured")
+		}
+	}
+
+	// a non positive interval doesn't check without pause
+	m.SetHealthCheck(0, 1, time.Hour)
+	m.Lock()
+	interval := m.checkInterval
+	m.Unlock()
+	if interval != defaultCheckInterval {
+		t.Fatal(interval)
+	}
+}
@@ -0,0 +1,175 @@
+package rpc
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides how a failed call is retried.
+//
+// A call which was not sent to server, e.g. failed to dial, is always retried,
+// otherwise it is retried only if it is marked idempotent by SetIdempotent and
+// Retryable returns true, so a call which may have been executed is never replayed silently.
+type RetryPolicy struct {
+	// max attempts including the first one, 1 means no retry
+	MaxAttempts int
+
+	// backoff before the nth retry is InitialBackoff * Multiplier^(n-1), at most MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// backoff is randomized by +/- Jitter, in [0, 1]
+	Jitter float64
+
+	// Retryable classifies errors of sent calls, nil means IsTransportError
+	// or an RpcError with CodeUnavailable
+	Retryable func(err error) bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// IsTransportError returns true if err is not an error replied by server,
+// e.g. connection broken.
+func IsTransportError(err error) bool {
+	_, ok := err.(RpcError)
+	return !ok
+}
+
+// notSentError means the call is never sent to server, so retrying it is safe.
+type notSentError struct {
+	err error
+}
+
+func (e notSentError) Error() string {
+	return e.err.Error()
+}
+
+func (e notSentError) Unwrap() error {
+	return e.err
+}
+
+func (p *RetryPolicy) canRetry(ctx context.Context, err error, idempotent bool) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if _, ok := err.(notSentError); ok {
+		return true
+	}
+
+	if !idempotent {
+		return false
+	}
+
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransportError(err) || CodeOf(err) == CodeUnavailable
+}
+
+func (p *RetryPolicy) backoff(retries int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < retries; i++ {
+		d *= p.Multiplier
+	}
+
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(d)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retrier retries calls by the retry policy.
+type retrier struct {
+	mutex      sync.Mutex
+	policy     RetryPolicy
+	idempotent map[string]struct{}
+}
+
+func (r *retrier) init() {
+	r.policy = DefaultRetryPolicy
+	r.idempotent = make(map[string]struct{})
+}
+
+// SetRetryPolicy sets the retry policy for calls, DefaultRetryPolicy is used by default.
+func (r *retrier) SetRetryPolicy(p RetryPolicy) {
+	r.mutex.Lock()
+	r.policy = p
+	r.mutex.Unlock()
+}
+
+// SetIdempotent marks rpc names as idempotent, so they can be retried even
+// if they may have been executed.
+func (r *retrier) SetIdempotent(names ...string) {
+	r.mutex.Lock()
+	for _, name := range names {
+		r.idempotent[name] = struct{}{}
+	}
+	r.mutex.Unlock()
+}
+
+// retry calls f for rpc name bound to function type ft until it succeeds
+// or the policy does not allow to retry.
+func (r *retrier) retry(ctx context.Context, name string, ft reflect.Type, f func() ([]interface{}, error)) ([]interface{}, error) {
+	r.mutex.Lock()
+	policy := r.policy
+	_, idempotent := r.idempotent[name]
+	r.mutex.Unlock()
+
+	if streamIndex(paramTypes(ft)) >= 0 {
+		// a streaming arg can not be replayed
+		idempotent = false
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var out []interface{}
+		if out, err = f(); err == nil {
+			return out, nil
+		}
+
+		if attempt >= policy.MaxAttempts || !policy.canRetry(ctx, err, idempotent) {
+			break
+		}
+
+		if e := sleepContext(ctx, policy.backoff(attempt)); e != nil {
+			break
+		}
+	}
+
+	if e, ok := err.(notSentError); ok {
+		err = e.err
+	}
+	return nil, err
+}
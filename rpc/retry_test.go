@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testRetryCalls int32
+
+func test_Retry(id int) error {
+	atomic.AddInt32(&testRetryCalls, 1)
+	return errors.New("retry error")
+}
+
+func TestRetryPolicy(t *testing.T) {
+	s := newTestServer()
+	s.Register("retry", test_Retry)
+	s.Register("retry_idempotent", test_Retry)
+
+	c := NewClient("tcp", "127.0.0.1:11182", 1)
+	defer c.Close()
+
+	p := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     2,
+		Retryable:      func(err error) bool { return true },
+	}
+	c.SetRetryPolicy(p)
+	c.SetIdempotent("retry_idempotent")
+
+	var r1 func(int) error
+	if err := c.MakeRpc("retry", &r1); err != nil {
+		t.Fatal(err)
+	}
+
+	var r2 func(int) error
+	if err := c.MakeRpc("retry_idempotent", &r2); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&testRetryCalls, 0)
+	if e := r1(1); e == nil {
+		t.Fatal("must error")
+	} else if n := atomic.LoadInt32(&testRetryCalls); n != 1 {
+		t.Fatal(n)
+	}
+
+	atomic.StoreInt32(&testRetryCalls, 0)
+	if e := r2(1); e == nil || e.Error() != "retry error" {
+		t.Fatal(e)
+	} else if n := atomic.LoadInt32(&testRetryCalls); n != 3 {
+		t.Fatal(n)
+	}
+
+	p.Retryable = nil
+	c.SetRetryPolicy(p)
+
+	atomic.StoreInt32(&testRetryCalls, 0)
+	if e := r2(1); e == nil {
+		t.Fatal("must error")
+	} else if n := atomic.LoadInt32(&testRetryCalls); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	if d := p.backoff(1); d != 10*time.Millisecond {
+		t.Fatal(d)
+	} else if d = p.backoff(3); d != 40*time.Millisecond {
+		t.Fatal(d)
+	} else if d = p.backoff(10); d != 50*time.Millisecond {
+		t.Fatal(d)
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(1); d < 5*time.Millisecond || d > 15*time.Millisecond {
+			t.Fatal(d)
+		}
+	}
+}
+
+func TestRetryNotSent(t *testing.T) {
+	c := NewClient("tcp", closedTestAddr(t), 1)
+	defer c.Close()
+
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: 20 * time.Millisecond, Multiplier: 1})
+
+	var r func(int) error
+	if err := c.MakeRpc("retry", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	t1 := time.Now()
+	if e := r(1); e == nil {
+		t.Fatal("must error")
+	} else if _, ok := e.(notSentError); ok {
+		t.Fatal("internal error leaked")
+	}
+
+	if d := time.Since(t1); d < 40*time.Millisecond {
+		t.Fatal(d)
+	}
+}
@@ -1,9 +1,12 @@
 package rpc
 
 import (
+	"context"
 	"errors"
+	"net"
 	"sync"
 	"testing"
+	"time"
 )
 
 var testServerOnce sync.Once
@@ -33,6 +36,32 @@ func newTestClient() *Client {
 	return testClient
 }
 
+// startTestServer starts s on a free port of localhost and returns its address
+// once it is accepting, s is stopped when the test ends.
+func startTestServer(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.serve(l)
+	t.Cleanup(func() { s.Stop() })
+
+	return l.Addr().String()
+}
+
+// closedTestAddr returns an address of localhost nothing listens on.
+func closedTestAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
 func test_Rpc1(id int) (int, string, error) {
 	return id * 10, "abc", nil
 }
@@ -133,3 +162,339 @@ func TestRpc3(t *testing.T) {
 		t.Fatal("must error")
 	}
 }
+
+func test_Rpc4(id int) (int, error) {
+	time.Sleep(time.Duration(id) * time.Millisecond)
+	return id, nil
+}
+
+func TestRpcMultiplex(t *testing.T) {
+	s := newTestServer()
+
+	s.Register("rpc4", test_Rpc4)
+
+	c := NewClient("tcp", "127.0.0.1:11182", 1)
+	defer c.Close()
+
+	var r func(int) (int, error)
+	if err := c.MakeRpc("rpc4", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if a, e := r(id % 20); e != nil {
+				t.Error(e)
+			} else if a != id%20 {
+				t.Error(a, id%20)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	c.Lock()
+	n := len(c.conns)
+	c.Unlock()
+
+	if n != 1 {
+		t.Fatal(n)
+	}
+
+	var r1 func(int) error
+	if err := c.MakeRpc("rpc_not_exist", &r1); err != nil {
+		t.Fatal(err)
+	} else if e := r1(1); e == nil {
+		t.Fatal("must error")
+	}
+}
+
+var testRpc5Done = make(chan error, 1)
+
+func test_Rpc5(ctx context.Context, id int) (int, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return 0, errors.New("no deadline")
+	}
+
+	if id == 0 {
+		return id, nil
+	}
+
+	<-ctx.Done()
+	testRpc5Done <- ctx.Err()
+	return id, ctx.Err()
+}
+
+func TestRpcContext(t *testing.T) {
+	s := newTestServer()
+
+	s.Register("rpc5", test_Rpc5)
+
+	c := newTestClient()
+
+	var r func(context.Context, int) (int, error)
+	if err := c.MakeRpc("rpc5", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	if _, e := r(ctx, 0); e != nil {
+		t.Fatal(e)
+	}
+	cancel()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	if _, e := r(ctx, 1); e != context.DeadlineExceeded {
+		t.Fatal(e)
+	}
+	cancel()
+
+	select {
+	case <-testRpc5Done:
+	case <-time.After(time.Second):
+		t.Fatal("server call not canceled")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	if _, e := r(ctx, 1); e != context.Canceled {
+		t.Fatal(e)
+	}
+
+	select {
+	case err := <-testRpc5Done:
+		if err != context.Canceled {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server call not canceled")
+	}
+}
+
+func TestCallContextTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// sent relative, so a skewed server clock doesn't matter
+	m := &Message{Timeout: contextTimeout(ctx)}
+	if m.Timeout <= 0 || m.Timeout > int64(time.Second) {
+		t.Fatal(m.Timeout)
+	}
+
+	cctx, ccancel := newCallContext(context.Background(), m)
+	defer ccancel()
+	if d, ok := cctx.Deadline(); !ok || time.Until(d) <= 900*time.Millisecond || time.Until(d) > time.Second {
+		t.Fatal(d, ok)
+	}
+
+	if n := contextTimeout(context.Background()); n != 0 {
+		t.Fatal(n)
+	}
+
+	expired, ecancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer ecancel()
+	if n := contextTimeout(expired); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestRpcHungPeer(t *testing.T) {
+	// accepts but never replies the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var conns []net.Conn
+	var mutex sync.Mutex
+	defer func() {
+		mutex.Lock()
+		for _, co := range conns {
+			co.Close()
+		}
+		mutex.Unlock()
+	}()
+
+	go func() {
+		for {
+			co, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			conns = append(conns, co)
+			mutex.Unlock()
+		}
+	}()
+
+	c := NewClient("tcp", ln.Addr().String(), 1)
+	c.SetLogger(nil)
+	defer c.Close()
+
+	var r func(context.Context, int) (int, error)
+	if err := c.MakeRpc("rpc5", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	// dials without deadline
+	go r(context.Background(), 0)
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := r(ctx, 0)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if CodeOf(err) != CodeDeadlineExceeded {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("call blocked by dialing")
+	}
+
+	// the client is not locked
+	c.SetCodecs(GobCodec)
+	c.Stats()
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:11183")
+	s.Register("sleep", test_Rpc4)
+
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start()
+	}()
+
+	c := NewClient("tcp", "127.0.0.1:11183", 1)
+	defer c.Close()
+
+	var r func(int) (int, error)
+	if err := c.MakeRpc("sleep", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	var e error
+	for i := 0; i < 10; i++ {
+		if _, e = r(0); e == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, e := r(200)
+		done <- e
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if n, err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+
+	if e := <-done; e != nil {
+		t.Fatal(e)
+	}
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("start not returned")
+	}
+
+	if _, e := r(0); e == nil {
+		t.Fatal("must error")
+	}
+}
+
+func TestServerShutdownBeforeStart(t *testing.T) {
+	for _, stop := range []func(s *Server){
+		func(s *Server) { s.Shutdown(context.Background()) },
+		func(s *Server) { s.Stop() },
+	} {
+		s := NewServer("tcp", "127.0.0.1:0")
+		stop(s)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Start()
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			s.Stop()
+			t.Fatal("started after shutdown")
+		}
+	}
+}
+
+func TestServerShutdownAbandon(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:11184")
+	s.Register("sleep", test_Rpc4)
+
+	go s.Start()
+
+	c := NewClient("tcp", "127.0.0.1:11184", 1)
+	defer c.Close()
+
+	var r func(int) (int, error)
+	if err := c.MakeRpc("sleep", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, e := r(0); e == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, e := r(1000)
+		done <- e
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if n, err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if e := <-done; e == nil {
+		t.Fatal("must error")
+	}
+}
@@ -1,10 +1,16 @@
 package rpc
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"reflect"
 	"sync"
+	"time"
+
+	"github.com/siddontang/go/log"
+	"github.com/siddontang/go/tb"
 )
 
 type Server struct {
@@ -16,60 +22,256 @@ type Server struct {
 
 	listener net.Listener
 	running  bool
+	// set by Stop, a server is not started again
+	stopped bool
+
+	// set by Shutdown, new calls are refused and drained connections closed
+	shutdown bool
+	conns    map[*serverConn]struct{}
+	// closed when all connections are closed after Shutdown
+	drained chan struct{}
+
+	// codecs the server supports, default all
+	codecs []Codec
+
+	tlsConfig *tls.Config
+
+	// send stack traces of errors to client
+	sendStack bool
+
+	// see SetCompression
+	compressThreshold int
+
+	// see SetOnConnect
+	onConnect func(p *Peer)
+
+	logger *log.Logger
+
+	// see SetMaxMessageSize and SetTimeouts
+	limits connLimits
+
+	stats stats
+
+	// concurrency limits of the server and of each method, see SetMaxConcurrency
+	limiter  *limiter
+	limiters map[string]*limiter
+
+	interceptors []Interceptor
+	invoke       Invoker
 }
 
 func NewServer(network, addr string) *Server {
 	RegisterType(RpcError{})
+	RegisterType([]MethodDesc{})
 
 	s := new(Server)
 	s.network = network
 	s.addr = addr
 
 	s.funcs = make(map[string]reflect.Value)
+	s.funcs[PingName] = reflect.ValueOf(ping)
+	s.funcs[DescribeName] = reflect.ValueOf(func() ([]MethodDesc, error) {
+		return s.Describe(), nil
+	})
+	s.conns = make(map[*serverConn]struct{})
+	s.limiters = make(map[string]*limiter)
+
+	s.codecs = defaultCodecs
+
+	s.invoke = s.call
+
+	s.logger = defaultLogger
+
+	s.limits = defaultLimits
 
 	return s
 }
 
+// SetSendStack sets whether the stack trace of a tb.StackError returned by a registered
+// function is sent to client, default false. It must be called before Start.
+func (s *Server) SetSendStack(send bool) {
+	s.sendStack = send
+}
+
+// Use appends interceptors to the server, a call passes through them in order
+// before the registered function is called.
+func (s *Server) Use(interceptors ...Interceptor) {
+	s.Lock()
+	s.interceptors = append(s.interceptors, interceptors...)
+	s.invoke = chainInterceptors(s.interceptors, s.call)
+	s.Unlock()
+}
+
+// SetCodecs sets the codecs the server supports, it must be called before Start.
+func (s *Server) SetCodecs(codecs ...Codec) {
+	s.codecs = codecs
+}
+
 func (s *Server) Start() error {
-	var err error
-	s.listener, err = net.Listen(s.network, s.addr)
+	l, err := net.Listen(s.network, s.addr)
 	if err != nil {
 		return err
 	}
 
+	return s.serve(l)
+}
+
+// serve accepts connections from l until the server is stopped.
+func (s *Server) serve(l net.Listener) error {
+	if s.tlsConfig != nil {
+		l = tls.NewListener(l, s.tlsConfig)
+	}
+
+	s.Lock()
+	if s.stopped || s.shutdown {
+		// stopped before listening
+		s.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listener = l
 	s.running = true
+	s.Unlock()
 
-	for s.running {
-		conn, err := s.listener.Accept()
+	for {
+		conn, err := l.Accept()
 		if err != nil {
+			if !s.isRunning() {
+				return nil
+			}
 			continue
 		}
 
 		go s.onConn(conn)
 	}
+}
 
-	return nil
+func (s *Server) isRunning() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.running
 }
 
+// Stop stops accepting and closes all connections, running calls are cut off.
 func (s *Server) Stop() error {
+	s.Lock()
 	s.running = false
+	s.stopped = true
 
 	if s.listener != nil {
 		s.listener.Close()
 	}
 
+	for c := range s.conns {
+		c.Close()
+	}
+	s.Unlock()
+
 	return nil
 }
 
+var errShutdown = NewError(CodeUnavailable, "rpc server is shutting down")
+
+// Shutdown stops accepting, refuses new calls, waits running calls to finish
+// and closes connections once they are idle.
+//
+// If ctx is done first, all connections are closed and the number of
+// abandoned calls is returned with ctx error.
+func (s *Server) Shutdown(ctx context.Context) (int, error) {
+	s.Lock()
+	s.running = false
+	s.shutdown = true
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	for c := range s.conns {
+		if c.calls() == 0 {
+			c.Close()
+		}
+	}
+
+	if s.drained == nil {
+		s.drained = make(chan struct{})
+		if len(s.conns) == 0 {
+			close(s.drained)
+		}
+	}
+	drained := s.drained
+	s.Unlock()
+
+	select {
+	case <-drained:
+		return 0, nil
+	case <-ctx.Done():
+		abandoned := 0
+
+		s.Lock()
+		for c := range s.conns {
+			abandoned += c.calls()
+			c.Close()
+		}
+		s.Unlock()
+
+		return abandoned, ctx.Err()
+	}
+}
+
+func (s *Server) isShutdown() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.shutdown
+}
+
+func (s *Server) addConn(c *serverConn) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.running {
+		return false
+	}
+
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) removeConn(c *serverConn) {
+	s.Lock()
+	delete(s.conns, c)
+	// no connection is added after Shutdown, so it is closed once
+	if s.drained != nil && len(s.conns) == 0 {
+		close(s.drained)
+	}
+	s.Unlock()
+}
+
 func (s *Server) Register(name string, f interface{}) (err error) {
+	v := reflect.ValueOf(f)
+	if err = checkFunc(name, v); err != nil {
+		return
+	}
+
+	s.Lock()
+	if _, ok := s.funcs[name]; ok {
+		err = fmt.Errorf("%s has registered", name)
+		s.Unlock()
+		return
+	}
+
+	s.funcs[name] = v
+	s.Unlock()
+	return
+}
+
+// checkFunc checks whether f can be registered as rpc name.
+func checkFunc(name string, v reflect.Value) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("%s is not callable", name)
 		}
 	}()
 
-	v := reflect.ValueOf(f)
-
 	//to check f is function
 	v.Type().NumIn()
 
@@ -85,90 +287,395 @@ func (s *Server) Register(name string, f interface{}) (err error) {
 		return
 	}
 
-	s.Lock()
-	if _, ok := s.funcs[name]; ok {
-		err = fmt.Errorf("%s has registered", name)
-		s.Unlock()
-		return
-	}
-
-	s.funcs[name] = v
-	s.Unlock()
-	return
+	return checkStreams(name, v.Type())
 }
 
 func (s *Server) onConn(co net.Conn) {
-	c := new(conn)
-	c.co = co
+	c := newServerConn(s, &conn{co: co, connLimits: s.limits})
+
+	if !s.addConn(c) {
+		c.Close()
+		return
+	}
 
 	defer func() {
-		if e := recover(); e != nil {
-			//later log
-			if err, ok := e.(error); ok {
-				println("recover", err.Error())
-			}
-		}
 		c.Close()
+		s.removeConn(c)
 	}()
 
+	var err error
+	if c.peer, err = newPeer(co); err != nil {
+		s.logger.Warnf("rpc conn %s tls handshake error %v", co.RemoteAddr(), err)
+		return
+	}
+
+	if err = c.serverHandshake(s.codecs, s.compressThreshold); err != nil {
+		s.logger.Warnf("rpc conn %s handshake error %v", co.RemoteAddr(), err)
+		return
+	}
+
+	if c.callbacks {
+		// the client serves calls made through its peer, see Peer.MakeRpc
+		c.peer.out = newClientConn(nil, c.conn, seqReverse)
+		defer c.peer.out.close(errPeerClosed)
+	}
+	defer close(c.peer.done)
+
+	if s.onConnect != nil {
+		go s.onConnect(c.peer)
+	}
+
 	for {
-		data, err := c.ReadMessage()
-		if err != nil {
-			println("read error ", err.Error())
+		seq, typ, data, err := c.ReadMessage()
+		if err == errIdle {
+			if c.busy() {
+				continue
+			}
+			s.logger.Debugf("rpc conn %s idle timeout", co.RemoteAddr())
 			return
+		} else if err != nil {
+			if isClosed(err) {
+				s.logger.Debugf("rpc conn %s closed", co.RemoteAddr())
+			} else {
+				s.logger.Warnf("rpc conn %s read error %v", co.RemoteAddr(), err)
+			}
+			return
+		}
+
+		if c.peer.out != nil && seq&seqReverse != 0 {
+			// reply or streaming result of a call made by the server
+			err = c.peer.out.dispatch(seq, typ, data)
+		} else {
+			err = c.dispatch(seq, typ, data)
 		}
 
-		data, err = s.handle(data)
 		if err != nil {
-			println("handle error ", err.Error())
+			s.logger.Errorf("rpc conn %s error %v", co.RemoteAddr(), err)
 			return
 		}
-		err = c.WriteMessage(data)
-		if err != nil {
-			println("write error ", err.Error())
+	}
+}
+
+// dispatch handles a frame of a call from the other side.
+func (c *serverConn) dispatch(seq uint32, typ byte, data []byte) error {
+	switch typ {
+	case msgCall:
+		// calls on one connection are served concurrently,
+		// the response carries the request seq so the client can match it
+		accepted := c.begin(seq)
+		go c.serve(seq, data, accepted)
+	case msgCancel:
+		c.cancel(seq)
+	case msgStream, msgStreamEnd:
+		c.pushStream(seq, typ, data)
+	case msgStreamAck:
+		return c.grantStream(seq, data)
+	default:
+		return &FrameError{seq, typ, len(data), "unexpected message type"}
+	}
+	return nil
+}
+
+// serverConn is the server side of a connection, it tracks running calls
+// so that they can be canceled by the client and waited by Shutdown.
+type serverConn struct {
+	*conn
+
+	s *Server
+
+	peer *Peer
+
+	mutex   sync.Mutex
+	cancels map[uint32]context.CancelFunc
+
+	// streaming args of running calls
+	streams map[uint32]*stream
+}
+
+func newServerConn(s *Server, co *conn) *serverConn {
+	c := new(serverConn)
+	c.conn = co
+	c.s = s
+	c.cancels = make(map[uint32]context.CancelFunc)
+	c.streams = make(map[uint32]*stream)
+	return c
+}
+
+// begin tracks the call, it returns false if the call must be refused
+// because the server is shutting down.
+func (c *serverConn) begin(seq uint32) bool {
+	c.mutex.Lock()
+	c.cancels[seq] = nil
+	c.mutex.Unlock()
+
+	return !c.s.isShutdown()
+}
+
+func (c *serverConn) end(seq uint32) {
+	c.mutex.Lock()
+	delete(c.cancels, seq)
+	n := len(c.cancels)
+	st, ok := c.streams[seq]
+	delete(c.streams, seq)
+	c.mutex.Unlock()
+
+	if ok {
+		st.abort(errStreamCanceled)
+	}
+
+	if n == 0 && c.s.isShutdown() {
+		c.Close()
+	}
+}
+
+// busy returns whether calls of either side are running on the connection.
+func (c *serverConn) busy() bool {
+	return c.calls() > 0 || (c.peer.out != nil && c.peer.out.busy())
+}
+
+func (c *serverConn) calls() int {
+	c.mutex.Lock()
+	n := len(c.cancels)
+	c.mutex.Unlock()
+	return n
+}
+
+// stream returns the stream of the call seq, it is created by the call
+// or the first stream frame, whichever comes first.
+func (c *serverConn) stream(seq uint32) *stream {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.cancels[seq]; !ok {
+		// call has ended
+		return nil
+	}
+
+	st, ok := c.streams[seq]
+	if !ok {
+		st = newStream(func(n int) { c.ackStream(seq, n) })
+		c.streams[seq] = st
+	}
+	return st
+}
+
+func (c *serverConn) pushStream(seq uint32, typ byte, data []byte) {
+	if st := c.stream(seq); st != nil && !st.push(typ, data) {
+		// the client ignored the credit, only this call fails
+		st.abort(&FrameError{seq, typ, len(data), "stream buffer overflow"})
+		c.cancel(seq)
+	}
+}
+
+func (c *serverConn) serve(seq uint32, data []byte, accepted bool) {
+	defer c.end(seq)
+
+	defer func() {
+		// a panic out of the registered function, e.g. in codec, breaks the connection
+		if e := recover(); e != nil {
+			c.s.logger.Errorf("rpc conn %s seq %d panic: %v\n%s", c.co.RemoteAddr(), seq, e, tb.Stack(3))
+			c.Close()
+		}
+	}()
+
+	start := time.Now()
+
+	d := new(Message)
+	argErr := c.codec.Decode(data, d, c.s.argTypes)
+	if argErr != nil {
+		if e, ok := argErr.(RpcError); !ok || e.Code != CodeInvalidArgument {
+			c.s.logger.Errorf("rpc conn %s decode error %v", c.co.RemoteAddr(), argErr)
+			c.Close()
 			return
 		}
+
+		// only the args are bad, reply the error and keep the connection for other calls
+		c.s.logger.Warnf("rpc %s from %s decode error %v", d.Name, c.co.RemoteAddr(), argErr)
+	}
+
+	name := d.Name
+	if c.s.funcType(name) == nil {
+		name = unregisteredMethod
+	}
+	received := len(data)
+
+	ctx, cancel := newCallContext(withPeer(context.Background(), c.peer), d)
+	defer cancel()
+
+	c.mutex.Lock()
+	c.cancels[seq] = cancel
+	c.mutex.Unlock()
+
+	var reply *Message
+	var src reflect.Value
+	if argErr != nil {
+		reply = &Message{Name: d.Name, Error: toRpcError(argErr, false)}
+	} else {
+		reply, src = c.handle(ctx, seq, d, accepted)
+	}
+
+	data, err := c.codec.Encode(reply)
+	if err == nil {
+		if err = c.checkWrite(data); err != nil {
+			// reply the error instead
+			reply, src = &Message{Name: d.Name, Error: toRpcError(err, false)}, reflect.Value{}
+			data, err = c.codec.Encode(reply)
+		}
+	}
+	if err != nil {
+		c.s.logger.Errorf("rpc %s from %s encode error %v", d.Name, c.co.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+
+	if reply.Error != nil {
+		err = reply.Error
+	}
+	c.s.stats.record(name, received, len(data), time.Since(start), err)
+
+	if err = c.WriteMessage(seq, msgReply, data); err != nil {
+		c.s.logger.Warnf("rpc %s from %s write error %v", d.Name, c.co.RemoteAddr(), err)
+		return
+	}
+
+	if src.IsValid() {
+		// streaming result is sent after reply until end or canceled
+		sendStream(c.conn, seq, d.Name, src, ctx.Done())
+	}
+}
+
+func (c *serverConn) cancel(seq uint32) {
+	c.mutex.Lock()
+	cancel, ok := c.cancels[seq]
+	c.mutex.Unlock()
+
+	if ok && cancel != nil {
+		cancel()
 	}
 }
 
-func (s *Server) handle(data []byte) ([]byte, error) {
-	name, args, err := decodeData(data)
+// handle handles the call and returns the reply and the streaming result if any.
+func (c *serverConn) handle(ctx context.Context, seq uint32, d *Message, accepted bool) (*Message, reflect.Value) {
+	reply := &Message{Name: d.Name}
+
+	if !accepted {
+		reply.Error = toRpcError(errShutdown, false)
+		return reply, reflect.Value{}
+	}
+
+	release, err := c.s.acquire(ctx, d.Name)
+	if err != nil {
+		reply.Error = toRpcError(err, false)
+		return reply, reflect.Value{}
+	}
+	defer release()
+
+	args := d.Args
+	ft := c.s.funcType(d.Name)
+	if ft != nil {
+		if types := paramTypes(ft); streamIndex(types) >= 0 {
+			args = joinStream(types, args, c.stream(seq), c.codec)
+		}
+	}
+
+	out, err := c.s.handle(ctx, d.Name, args)
 	if err != nil {
-		return nil, err
+		// reply an error instead of closing a connection shared by other calls
+		reply.Error = toRpcError(err, c.s.sendStack)
+		return reply, reflect.Value{}
+	}
+
+	var src reflect.Value
+	if ft != nil {
+		if out, src, err = splitStream(resultTypes(ft), out); err != nil {
+			reply.Error = toRpcError(err, false)
+			return reply, reflect.Value{}
+		}
 	}
+	reply.Args = out
+
+	return reply, src
+}
 
+func (s *Server) funcType(name string) reflect.Type {
 	s.Lock()
 	f, ok := s.funcs[name]
 	s.Unlock()
+
 	if !ok {
-		return nil, fmt.Errorf("rpc %s not registered", name)
+		return nil
+	}
+	return f.Type()
+}
+
+func (s *Server) argTypes(name string) []reflect.Type {
+	if t := s.funcType(name); t != nil {
+		return argTypes(t)
+	}
+	return nil
+}
+
+func (s *Server) handle(ctx context.Context, name string, args []interface{}) ([]interface{}, error) {
+	s.Lock()
+	invoke := s.invoke
+	s.Unlock()
+
+	return invoke(ctx, name, args)
+}
+
+// call calls the registered function, it is the innermost invoker.
+func (s *Server) call(ctx context.Context, name string, args []interface{}) (outArgs []interface{}, err error) {
+	s.Lock()
+	f, ok := s.funcs[name]
+	s.Unlock()
+	if !ok {
+		return nil, NewError(CodeUnimplemented, "rpc %s not registered", name)
+	}
+
+	// a panic is replied as an error, other calls on the connection go on
+	defer func() {
+		if e := recover(); e != nil {
+			s.logger.Errorf("rpc %s panic: %v\n%s", name, e, tb.Stack(3))
+			outArgs, err = nil, NewError(CodeInternal, "rpc %s panic: %v", name, e)
+		}
+	}()
+
+	if hasContext(f.Type()) {
+		args = append([]interface{}{ctx}, args...)
+	}
+
+	if !f.Type().IsVariadic() && len(args) != f.Type().NumIn() {
+		return nil, NewError(CodeInvalidArgument, "rpc %s needs %d args, not %d", name, f.Type().NumIn(), len(args))
 	}
 
 	inValues := make([]reflect.Value, len(args))
 
 	for i := 0; i < len(args); i++ {
-		if args[i] == nil {
-			inValues[i] = reflect.Zero(f.Type().In(i))
-		} else {
-			inValues[i] = reflect.ValueOf(args[i])
+		t := f.Type().In(i)
+		if f.Type().IsVariadic() && i >= f.Type().NumIn()-1 {
+			t = f.Type().In(f.Type().NumIn() - 1).Elem()
 		}
+		v, err := valueOf(args[i], t)
+		if err != nil {
+			return nil, NewError(CodeInvalidArgument, "rpc %s arg %d: %v", name, i, err)
+		}
+		inValues[i] = v
 	}
 
 	out := f.Call(inValues)
 
-	outArgs := make([]interface{}, len(out))
-	for i := 0; i < len(outArgs); i++ {
-		outArgs[i] = out[i].Interface()
-	}
-
-	p := out[len(out)-1].Interface()
-	if p != nil {
+	if p := out[len(out)-1].Interface(); p != nil {
 		if e, ok := p.(error); ok {
-			outArgs[len(out)-1] = RpcError{e.Error()}
+			return nil, e
 		} else {
-			return nil, fmt.Errorf("final param must be error")
+			return nil, NewError(CodeInternal, "final param must be error")
 		}
 	}
 
-	return encodeData(name, outArgs)
+	outArgs = make([]interface{}, len(out)-1)
+	for i := 0; i < len(outArgs); i++ {
+		outArgs[i] = argValue(out[i])
+	}
+
+	return outArgs, nil
 }
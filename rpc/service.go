@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// RegisterService registers every exported method of receiver as rpc "name.Method",
+// methods which can't be registered, e.g. without a final error result, are skipped.
+// Nothing is registered if any name has been registered.
+func (s *Server) RegisterService(name string, receiver interface{}) error {
+	v := reflect.ValueOf(receiver)
+	t := v.Type()
+
+	funcs := make(map[string]reflect.Value)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if len(m.PkgPath) > 0 {
+			// unexported
+			continue
+		}
+
+		rpcName := name + "." + m.Name
+		if f := v.Method(i); checkFunc(rpcName, f) == nil {
+			funcs[rpcName] = f
+		}
+	}
+
+	if len(funcs) == 0 {
+		return fmt.Errorf("%s has no method to register", name)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for rpcName := range funcs {
+		if _, ok := s.funcs[rpcName]; ok {
+			return fmt.Errorf("%s has registered", rpcName)
+		}
+	}
+
+	for rpcName, f := range funcs {
+		s.funcs[rpcName] = f
+	}
+	return nil
+}
+
+// RegisterService registers the methods of receiver which the server can call,
+// see Server.RegisterService and Register.
+func (c *Client) RegisterService(name string, receiver interface{}) error {
+	return c.callbacks.RegisterService(name, receiver)
+}
+
+// makeService binds every exported function field of the struct pointed by sptr
+// to rpc "name.Field" by makeRpc. Tag `rpc:"Method"` binds the field to "name.Method",
+// and `rpc:"-"` skips it. The struct is not changed if any field fails.
+func makeService(name string, sptr interface{}, makeRpc func(rpcName string, fptr interface{}) error) error {
+	v := reflect.ValueOf(sptr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%s needs a pointer to struct, not %T", name, sptr)
+	}
+
+	t := v.Elem().Type()
+
+	// bind to a copy first
+	sv := reflect.New(t).Elem()
+	sv.Set(v.Elem())
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) > 0 || field.Type.Kind() != reflect.Func {
+			continue
+		}
+
+		method := field.Name
+		if tag := field.Tag.Get("rpc"); tag == "-" {
+			continue
+		} else if len(tag) > 0 {
+			method = tag
+		}
+
+		if err := makeRpc(name+"."+method, sv.Field(i).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+
+	v.Elem().Set(sv)
+	return nil
+}
+
+// MakeService binds every exported function field of the struct pointed by sptr to
+// the method of service name registered by Server.RegisterService, see MakeRpc.
+//
+//	var arith struct {
+//		Add func(int, int) (int, error)
+//		Sub func(int, int) (int, error) `rpc:"Subtract"`
+//	}
+//	err := c.MakeService("Arith", &arith)
+func (c *Client) MakeService(name string, sptr interface{}) error {
+	return makeService(name, sptr, c.MakeRpc)
+}
+
+// MakeService binds the function fields of sptr, see Client.MakeService.
+func (m *MultiClient) MakeService(name string, sptr interface{}) error {
+	return makeService(name, sptr, m.MakeRpc)
+}
+
+// MakeService binds the function fields of sptr to the service registered by
+// Client.RegisterService, see Client.MakeService.
+func (p *Peer) MakeService(name string, sptr interface{}) error {
+	return makeService(name, sptr, p.MakeRpc)
+}
@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+)
+
+type testArith struct {
+	base int
+}
+
+func (a *testArith) Add(x, y int) (int, error) {
+	return a.base + x + y, nil
+}
+
+func (a *testArith) Subtract(ctx context.Context, x, y int) (int, error) {
+	return x - y, nil
+}
+
+// not registered without a final error
+func (a *testArith) Base() int {
+	return a.base
+}
+
+func (a *testArith) unexported() error {
+	return nil
+}
+
+func TestService(t *testing.T) {
+	// a dedicated server, the duplicate check fails on a shared one if the test is repeated
+	s := NewServer("tcp", "127.0.0.1:0")
+	addr := startTestServer(t, s)
+
+	if err := s.RegisterService("Arith", &testArith{base: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RegisterService("Arith", &testArith{}); err == nil {
+		t.Fatal("must error")
+	}
+	if err := s.RegisterService("Empty", struct{}{}); err == nil {
+		t.Fatal("must error")
+	}
+
+	if ft := s.funcType("Arith.Base"); ft != nil {
+		t.Fatal("Base must not be registered")
+	}
+
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	var arith struct {
+		Add func(int, int) (int, error)
+		Sub func(int, int) (int, error) `rpc:"Subtract"`
+
+		Skip func() error `rpc:"-"`
+		Name string
+	}
+	arith.Name = "arith"
+
+	if err := c.MakeService("Arith", &arith); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, err := arith.Add(1, 2); err != nil || a != 103 {
+		t.Fatal(a, err)
+	}
+	if a, err := arith.Sub(5, 2); err != nil || a != 3 {
+		t.Fatal(a, err)
+	}
+	if arith.Skip != nil || arith.Name != "arith" {
+		t.Fatal("must be skipped")
+	}
+
+	var bad struct {
+		Add func(int, int) int
+	}
+	if err := c.MakeService("Arith", &bad); err == nil {
+		t.Fatal("must error")
+	}
+	if err := c.MakeService("Arith", arith); err == nil {
+		t.Fatal("must error")
+	}
+}
@@ -0,0 +1,248 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram of MethodStats.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// calls of unregistered names are counted together, so clients can't grow the stats.
+const unregisteredMethod = "<unregistered>"
+
+// MethodStats is the stats of calls of a method, bytes are of call and reply
+// messages, streaming elements are not counted.
+type MethodStats struct {
+	Calls    int64
+	Errors   int64
+	BytesIn  int64
+	BytesOut int64
+
+	TotalLatency time.Duration
+	// Latency[i] counts calls not slower than LatencyBuckets[i],
+	// the last one counts the slower calls.
+	Latency []int64
+}
+
+func (m *MethodStats) add(in, out int, d time.Duration, err error) {
+	m.Calls++
+	if err != nil {
+		m.Errors++
+	}
+	m.BytesIn += int64(in)
+	m.BytesOut += int64(out)
+	m.TotalLatency += d
+
+	if m.Latency == nil {
+		m.Latency = make([]int64, len(LatencyBuckets)+1)
+	}
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	m.Latency[i]++
+}
+
+// AvgLatency returns the average latency of calls.
+func (m MethodStats) AvgLatency() time.Duration {
+	if m.Calls == 0 {
+		return 0
+	}
+	return m.TotalLatency / time.Duration(m.Calls)
+}
+
+func (m MethodStats) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "calls=%d errors=%d bytes_in=%d bytes_out=%d avg_latency=%v latency=[",
+		m.Calls, m.Errors, m.BytesIn, m.BytesOut, m.AvgLatency())
+	for i, n := range m.Latency {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		if i < len(LatencyBuckets) {
+			fmt.Fprintf(&buf, "%v:%d", LatencyBuckets[i], n)
+		} else {
+			fmt.Fprintf(&buf, "+Inf:%d", n)
+		}
+	}
+	buf.WriteByte(']')
+	return buf.String()
+}
+
+// stats records calls by method, a nil stats records nothing.
+type stats struct {
+	sync.Mutex
+	methods map[string]*MethodStats
+}
+
+func (s *stats) record(name string, in, out int, d time.Duration, err error) {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	if s.methods == nil {
+		s.methods = make(map[string]*MethodStats)
+	}
+	m, ok := s.methods[name]
+	if !ok {
+		m = new(MethodStats)
+		s.methods[name] = m
+	}
+	m.add(in, out, d, err)
+	s.Unlock()
+}
+
+func (s *stats) snapshot() map[string]MethodStats {
+	s.Lock()
+	defer s.Unlock()
+
+	methods := make(map[string]MethodStats, len(s.methods))
+	for name, m := range s.methods {
+		c := *m
+		c.Latency = append([]int64(nil), m.Latency...)
+		methods[name] = c
+	}
+	return methods
+}
+
+func methodsText(buf *bytes.Buffer, methods map[string]MethodStats) {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(buf, "%s %v\n", name, methods[name])
+	}
+}
+
+// ServerStats is a snapshot of the stats of a Server.
+type ServerStats struct {
+	Conns   int
+	Methods map[string]MethodStats
+}
+
+// Stats returns a snapshot of the stats of the server.
+func (s *Server) Stats() ServerStats {
+	s.Lock()
+	n := len(s.conns)
+	s.Unlock()
+
+	return ServerStats{Conns: n, Methods: s.stats.snapshot()}
+}
+
+// StatsJSON returns stats as a JSON object in a string.
+func (s *Server) StatsJSON() string {
+	if s == nil {
+		return "{}"
+	}
+	data, _ := json.Marshal(s.Stats())
+	return string(data)
+}
+
+// StatsText returns stats as text, a line per method.
+func (s *Server) StatsText() string {
+	if s == nil {
+		return ""
+	}
+
+	st := s.Stats()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "conns=%d\n", st.Conns)
+	methodsText(&buf, st.Methods)
+	return buf.String()
+}
+
+// PoolStats is the stats of the connections of a Client.
+type PoolStats struct {
+	// connections without pending calls
+	Idle  int
+	InUse int
+
+	Dials        int64
+	DialFailures int64
+}
+
+// ClientStats is a snapshot of the stats of a Client, every attempt of a
+// retried call is counted.
+type ClientStats struct {
+	Pool    PoolStats
+	Methods map[string]MethodStats
+}
+
+// Stats returns a snapshot of the stats of the client.
+func (c *Client) Stats() ClientStats {
+	pool := PoolStats{Dials: c.dials.Get(), DialFailures: c.dialFailures.Get()}
+
+	c.Lock()
+	for _, cc := range c.conns {
+		if cc.pendingNum() == 0 {
+			pool.Idle++
+		} else {
+			pool.InUse++
+		}
+	}
+	c.Unlock()
+
+	return ClientStats{Pool: pool, Methods: c.stats.snapshot()}
+}
+
+// StatsJSON returns stats as a JSON object in a string.
+func (c *Client) StatsJSON() string {
+	if c == nil {
+		return "{}"
+	}
+	data, _ := json.Marshal(c.Stats())
+	return string(data)
+}
+
+// StatsText returns stats as text, a line per method.
+func (c *Client) StatsText() string {
+	if c == nil {
+		return ""
+	}
+
+	st := c.Stats()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "idle=%d in_use=%d dials=%d dial_failures=%d\n",
+		st.Pool.Idle, st.Pool.InUse, st.Pool.Dials, st.Pool.DialFailures)
+	methodsText(&buf, st.Methods)
+	return buf.String()
+}
+
+// StatsReporter is implemented by Server and Client.
+type StatsReporter interface {
+	StatsJSON() string
+	StatsText() string
+}
+
+// StatsHandler returns an http handler writing the stats of r as JSON,
+// or as text with query format=text.
+func StatsHandler(r StatsReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, r.StatsText())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, r.StatsJSON())
+	})
+}
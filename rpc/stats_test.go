@@ -0,0 +1,94 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.Register("stats", func(a int) (int, error) {
+		if a < 0 {
+			return 0, errors.New("negative")
+		}
+		return a, nil
+	})
+	addr := startTestServer(t, s)
+
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	var r func(int) (int, error)
+	if err := c.MakeRpc("stats", &r); err != nil {
+		t.Fatal(err)
+	}
+	r(1)
+	r(2)
+	r(-1)
+
+	var u func() error
+	c.MakeRpc("stats_unregistered", &u)
+	u()
+
+	st := s.Stats()
+	if st.Conns != 1 {
+		t.Fatal(st.Conns)
+	}
+	m := st.Methods["stats"]
+	if m.Calls != 3 || m.Errors != 1 || m.BytesIn == 0 || m.BytesOut == 0 {
+		t.Fatal(m)
+	}
+	var n int64
+	for _, v := range m.Latency {
+		n += v
+	}
+	if n != 3 || len(m.Latency) != len(LatencyBuckets)+1 {
+		t.Fatal(m.Latency)
+	}
+	if m := st.Methods[unregisteredMethod]; m.Calls != 1 || m.Errors != 1 {
+		t.Fatal(m)
+	}
+
+	cs := c.Stats()
+	if cs.Pool.Dials != 1 || cs.Pool.DialFailures != 0 || cs.Pool.Idle != 1 || cs.Pool.InUse != 0 {
+		t.Fatal(cs.Pool)
+	}
+	if m := cs.Methods["stats"]; m.Calls != 3 || m.Errors != 1 || m.BytesOut != st.Methods["stats"].BytesIn {
+		t.Fatal(m)
+	}
+
+	var js ServerStats
+	if err := json.Unmarshal([]byte(s.StatsJSON()), &js); err != nil {
+		t.Fatal(err)
+	} else if js.Methods["stats"].Calls != 3 {
+		t.Fatal(js)
+	}
+
+	if text := c.StatsText(); !strings.Contains(text, "stats calls=3 errors=1") {
+		t.Fatal(text)
+	}
+
+	w := httptest.NewRecorder()
+	StatsHandler(s).ServeHTTP(w, httptest.NewRequest("GET", "/stats?format=text", nil))
+	if !strings.HasPrefix(w.Body.String(), "conns=1\n") {
+		t.Fatal(w.Body.String())
+	}
+
+	bad := NewClient("tcp", closedTestAddr(t), 1)
+	defer bad.Close()
+	bad.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	var b func() error
+	bad.MakeRpc("stats", &b)
+	b()
+	if cs := bad.Stats(); cs.Pool.DialFailures != 1 || cs.Methods["stats"].Errors != 1 {
+		t.Fatal(cs)
+	}
+
+	var nilServer *Server
+	if nilServer.StatsJSON() != "{}" {
+		t.Fatal("nil server stats")
+	}
+}
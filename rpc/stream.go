@@ -0,0 +1,427 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// A streaming param or result is a channel, or an iterator func() (T, bool, error)
+// which returns false at end. Its elements are not sent in the call or reply message,
+// but as stream frames with the seq of the call after it, terminated by an end
+// frame carrying an optional error.
+//
+// A function can have at most one streaming param and one streaming result.
+// A stream received as channel is closed at end and the error is dropped,
+// use an iterator to see it.
+//
+// Streams are flow controlled: the sender may send streamBufferSize elements
+// ahead, the receiver acks the taken ones with a credit frame, so a slow receiver
+// only stalls its own stream, never the connection shared by other calls.
+// A function bound by MakeRpc with a streaming result must take a context.Context,
+// canceling it stops a stream not read to end, otherwise the server keeps the call
+// until the connection is closed.
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+var errStreamCanceled = errors.New("rpc stream canceled")
+
+const streamBufferSize = 64
+
+func isStreamType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan:
+		return t.ChanDir()&reflect.RecvDir != 0
+	case reflect.Func:
+		return t.NumIn() == 0 && t.NumOut() == 3 && t.Out(1).Kind() == reflect.Bool && t.Out(2) == errorType
+	}
+	return false
+}
+
+func streamElemType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Chan {
+		return t.Elem()
+	}
+	return t.Out(0)
+}
+
+// streamIndex returns the index of the streaming type in types, or -1.
+func streamIndex(types []reflect.Type) int {
+	for i, t := range types {
+		if isStreamType(t) {
+			return i
+		}
+	}
+	return -1
+}
+
+func checkStreams(name string, t reflect.Type) error {
+	in, out := 0, 0
+	for _, pt := range paramTypes(t) {
+		if isStreamType(pt) {
+			in++
+		}
+	}
+	for _, rt := range resultTypes(t) {
+		if isStreamType(rt) {
+			out++
+		}
+	}
+
+	if in > 1 || out > 1 {
+		return fmt.Errorf("%s can have at most one streaming param and result", name)
+	}
+	return nil
+}
+
+// wireTypes returns types without the streaming one, which is not sent in the message.
+func wireTypes(types []reflect.Type) []reflect.Type {
+	i := streamIndex(types)
+	if i < 0 {
+		return types
+	}
+
+	w := make([]reflect.Type, 0, len(types)-1)
+	w = append(w, types[:i]...)
+	return append(w, types[i+1:]...)
+}
+
+// splitStream splits args of types into the args sent in the message and the streaming one.
+func splitStream(types []reflect.Type, args []interface{}) ([]interface{}, reflect.Value, error) {
+	i := streamIndex(types)
+	if i < 0 || i >= len(args) {
+		return args, reflect.Value{}, nil
+	}
+
+	w := make([]interface{}, 0, len(args)-1)
+	w = append(w, args[:i]...)
+	w = append(w, args[i+1:]...)
+
+	v, err := valueOf(args[i], types[i])
+	return w, v, err
+}
+
+// joinStream inserts the received stream to args decoded from the message.
+func joinStream(types []reflect.Type, args []interface{}, st *stream, codec Codec) []interface{} {
+	i := streamIndex(types)
+	if i < 0 || i > len(args) {
+		return args
+	}
+
+	j := make([]interface{}, 0, len(args)+1)
+	j = append(j, args[:i]...)
+	j = append(j, st.value(types[i], codec).Interface())
+	return append(j, args[i:]...)
+}
+
+type streamFrame struct {
+	typ  byte
+	data []byte
+}
+
+// stream receives the stream frames of one call.
+type stream struct {
+	// one more for the end frame, which needs no credit
+	frames chan streamFrame
+
+	once sync.Once
+	done chan struct{}
+	err  error
+
+	// sends a credit of n frames to the sender
+	ack func(n int)
+	// frames taken but not acked
+	taken int
+
+	// stops the stream and tells the sender, set for a streaming result
+	cancel func(err error)
+}
+
+func newStream(ack func(n int)) *stream {
+	st := new(stream)
+	st.frames = make(chan streamFrame, streamBufferSize+1)
+	st.done = make(chan struct{})
+	st.ack = ack
+	return st
+}
+
+// abort discards the stream, pending and later frames are dropped,
+// the receiver gets err if not nil.
+func (st *stream) abort(err error) {
+	st.once.Do(func() {
+		st.err = err
+		close(st.done)
+	})
+}
+
+// push is called by the read loop of the connection, it never blocks.
+// It returns false if the buffer is full, i.e. the sender ignored the credit.
+func (st *stream) push(typ byte, data []byte) bool {
+	select {
+	case st.frames <- streamFrame{typ, data}:
+		return true
+	case <-st.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// took acks every half buffer of taken frames, so the sender rarely waits.
+func (st *stream) took() {
+	st.taken++
+	if st.taken >= streamBufferSize/2 {
+		st.ack(st.taken)
+		st.taken = 0
+	}
+}
+
+// next returns the next element, ok is false at end.
+func (st *stream) next(codec Codec, t reflect.Type) (interface{}, bool, error) {
+	var f streamFrame
+	select {
+	case f = <-st.frames:
+	case <-st.done:
+		return nil, false, st.err
+	}
+
+	m := new(Message)
+	if f.typ == msgStreamEnd {
+		st.abort(nil)
+
+		if len(f.data) == 0 {
+			return nil, false, nil
+		} else if err := codec.Decode(f.data, m, func(string) []reflect.Type { return nil }); err != nil {
+			return nil, false, err
+		} else if m.Error != nil {
+			return nil, false, *m.Error
+		}
+		return nil, false, nil
+	}
+
+	st.took()
+
+	types := []reflect.Type{t}
+	if err := codec.Decode(f.data, m, func(string) []reflect.Type { return types }); err != nil {
+		st.abort(err)
+		return nil, false, err
+	} else if len(m.Args) != 1 {
+		err = fmt.Errorf("rpc stream frame has %d elements", len(m.Args))
+		st.abort(err)
+		return nil, false, err
+	}
+
+	if _, err := valueOf(m.Args[0], t); err != nil {
+		st.abort(err)
+		return nil, false, err
+	}
+
+	return m.Args[0], true, nil
+}
+
+// value returns a channel or an iterator of type t fed by the stream.
+func (st *stream) value(t reflect.Type, codec Codec) reflect.Value {
+	elemType := streamElemType(t)
+
+	if t.Kind() == reflect.Func {
+		return reflect.MakeFunc(t, func([]reflect.Value) []reflect.Value {
+			v, ok, err := st.next(codec, elemType)
+
+			errValue := reflect.Zero(errorType)
+			if err != nil {
+				errValue = reflect.ValueOf(&err).Elem()
+			}
+
+			// checked by next
+			rv, _ := valueOf(v, elemType)
+			return []reflect.Value{rv, reflect.ValueOf(ok).Convert(t.Out(1)), errValue}
+		})
+	}
+
+	ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, elemType), 0)
+
+	go func() {
+		defer ch.Close()
+
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: ch},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(st.done)},
+		}
+
+		for {
+			v, ok, _ := st.next(codec, elemType)
+			if !ok {
+				return
+			}
+
+			cases[0].Send, _ = valueOf(v, elemType)
+			if i, _, _ := reflect.Select(cases); i == 1 {
+				return
+			}
+		}
+	}()
+
+	return ch.Convert(t)
+}
+
+// sendStream sends the elements of a channel or an iterator as stream frames,
+// then an end frame with the error if any. It stops if done is closed.
+func sendStream(c *conn, seq uint32, name string, v reflect.Value, done <-chan struct{}) error {
+	credit := c.addCredit(seq)
+	defer c.removeCredit(seq)
+
+	err := pumpStream(v, done, func(elem interface{}) error {
+		data, err := c.codec.Encode(&Message{Name: name, Args: []interface{}{elem}})
+		if err != nil {
+			return err
+		} else if err = c.checkWrite(data); err != nil {
+			return err
+		} else if !credit.take(done) {
+			return errStreamCanceled
+		}
+		return c.WriteMessage(seq, msgStream, data)
+	})
+
+	var data []byte
+	if err != nil {
+		data, _ = c.codec.Encode(&Message{Name: name, Error: toRpcError(err, false)})
+	}
+
+	if e := c.WriteMessage(seq, msgStreamEnd, data); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func pumpStream(v reflect.Value, done <-chan struct{}, f func(elem interface{}) error) (err error) {
+	if v.IsNil() {
+		return nil
+	}
+
+	// an iterator may panic, it ends the stream instead of the process
+	defer func() {
+		if e := recover(); e != nil {
+			err = NewError(CodeInternal, "stream panic: %v", e)
+		}
+	}()
+
+	if v.Kind() == reflect.Chan {
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: v},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+		}
+
+		for {
+			i, elem, ok := reflect.Select(cases)
+			if i == 1 {
+				return errStreamCanceled
+			} else if !ok {
+				return nil
+			}
+
+			if err := f(argValue(elem)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-done:
+			return errStreamCanceled
+		default:
+		}
+
+		out := v.Call(nil)
+		if e := out[2].Interface(); e != nil {
+			return e.(error)
+		} else if !out[1].Bool() {
+			return nil
+		}
+
+		if err := f(argValue(out[0])); err != nil {
+			return err
+		}
+	}
+}
+
+// streamCredit counts the frames a stream sender may send before the receiver acks.
+type streamCredit struct {
+	mutex sync.Mutex
+	n     int
+	wake  chan struct{}
+}
+
+// take waits for a credit, it returns false if done is closed first.
+func (c *streamCredit) take(done <-chan struct{}) bool {
+	for {
+		c.mutex.Lock()
+		if c.n > 0 {
+			c.n--
+			c.mutex.Unlock()
+			return true
+		}
+		c.mutex.Unlock()
+
+		select {
+		case <-c.wake:
+		case <-done:
+			return false
+		}
+	}
+}
+
+func (c *streamCredit) add(n int) {
+	c.mutex.Lock()
+	c.n += n
+	c.mutex.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *conn) addCredit(seq uint32) *streamCredit {
+	credit := &streamCredit{n: streamBufferSize, wake: make(chan struct{}, 1)}
+
+	c.sMutex.Lock()
+	if c.credits == nil {
+		c.credits = make(map[uint32]*streamCredit)
+	}
+	c.credits[seq] = credit
+	c.sMutex.Unlock()
+
+	return credit
+}
+
+func (c *conn) removeCredit(seq uint32) {
+	c.sMutex.Lock()
+	delete(c.credits, seq)
+	c.sMutex.Unlock()
+}
+
+// ackStream sends a credit of n frames for the stream seq.
+func (c *conn) ackStream(seq uint32, n int) {
+	var data [4]byte
+	binary.LittleEndian.PutUint32(data[:], uint32(n))
+	c.WriteMessage(seq, msgStreamAck, data[:])
+}
+
+// grantStream handles a credit frame, a stream already ended is ignored.
+func (c *conn) grantStream(seq uint32, data []byte) error {
+	if len(data) != 4 {
+		return &FrameError{seq, msgStreamAck, len(data), "invalid stream credit"}
+	}
+
+	c.sMutex.Lock()
+	credit := c.credits[seq]
+	c.sMutex.Unlock()
+
+	if credit != nil {
+		credit.add(int(binary.LittleEndian.Uint32(data)))
+	}
+	return nil
+}
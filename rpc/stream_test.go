@@ -0,0 +1,316 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func test_StreamRange(n int) (<-chan int, error) {
+	if n < 0 {
+		return nil, errors.New("invalid n")
+	}
+
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < n; i++ {
+			ch <- i
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func test_StreamIter(n int) (func() (string, bool, error), error) {
+	i := 0
+	return func() (string, bool, error) {
+		if i == n {
+			return "", false, errors.New("iter error")
+		}
+		i++
+		return "a", true, nil
+	}, nil
+}
+
+func test_StreamSum(base int, nums <-chan int) (int, error) {
+	for n := range nums {
+		base += n
+	}
+	return base, nil
+}
+
+func test_StreamForever(ctx context.Context) (<-chan int, error) {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; ; i++ {
+			select {
+			case ch <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func TestServerStream(t *testing.T) {
+	s := newTestServer()
+	s.Register("stream_range", test_StreamRange)
+	s.Register("stream_iter", test_StreamIter)
+
+	c := newTestClient()
+
+	var r func(context.Context, int) (<-chan int, error)
+	if err := c.MakeRpc("stream_range", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	ch, e := r(ctx, 1000)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	n := 0
+	for v := range ch {
+		if v != n {
+			t.Fatal(v, n)
+		}
+		n++
+	}
+	if n != 1000 {
+		t.Fatal(n)
+	}
+
+	if _, e = r(ctx, -1); e == nil {
+		t.Fatal("must error")
+	}
+
+	var it func(context.Context, int) (func() (string, bool, error), error)
+	if err := c.MakeRpc("stream_iter", &it); err != nil {
+		t.Fatal(err)
+	}
+
+	next, e := it(ctx, 3)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	n = 0
+	for {
+		v, ok, err := next()
+		if err != nil {
+			if err.Error() != "iter error" {
+				t.Fatal(err)
+			}
+			break
+		} else if !ok {
+			t.Fatal("must error at end")
+		} else if v != "a" {
+			t.Fatal(v)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Fatal(n)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	s := newTestServer()
+	s.Register("stream_sum", test_StreamSum)
+
+	c := newTestClient()
+
+	var r func(int, <-chan int) (int, error)
+	if err := c.MakeRpc("stream_sum", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan int)
+	go func() {
+		for i := 1; i <= 100; i++ {
+			ch <- i
+		}
+		close(ch)
+	}()
+
+	if n, e := r(10, ch); e != nil {
+		t.Fatal(e)
+	} else if n != 5060 {
+		t.Fatal(n)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	s := newTestServer()
+	s.Register("stream_forever", test_StreamForever)
+
+	c := newTestClient()
+
+	var r func(context.Context) (<-chan int, error)
+	if err := c.MakeRpc("stream_forever", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, e := r(ctx)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for v := range ch {
+		if v == 10 {
+			cancel()
+			break
+		}
+	}
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("stream not canceled")
+		}
+	}
+}
+
+var testStreamStopped = make(chan struct{}, 2)
+
+func test_StreamSlow(ctx context.Context) (<-chan int, error) {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; ; i++ {
+			select {
+			case ch <- i:
+			case <-ctx.Done():
+				testStreamStopped <- struct{}{}
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func test_StreamSlowIter(ctx context.Context) (func() (int, bool, error), error) {
+	// the call ends only after the stream
+	go func() {
+		<-ctx.Done()
+		testStreamStopped <- struct{}{}
+	}()
+
+	i := 0
+	return func() (int, bool, error) {
+		i++
+		return i, true, nil
+	}, nil
+}
+
+func TestStreamSlowReader(t *testing.T) {
+	s := newTestServer()
+	s.Register("stream_slow", test_StreamSlow)
+	s.Register("stream_slow_iter", test_StreamSlowIter)
+	s.Register("rpc1", test_Rpc1)
+
+	// all calls share one connection
+	c := NewClient("tcp", "127.0.0.1:11182", 1)
+	defer c.Close()
+
+	var r func(context.Context) (<-chan int, error)
+	var it func(context.Context) (func() (int, bool, error), error)
+	var r1 func(int) (int, string, error)
+	if err := c.MakeRpc("stream_slow", &r); err != nil {
+		t.Fatal(err)
+	} else if err = c.MakeRpc("stream_slow_iter", &it); err != nil {
+		t.Fatal(err)
+	} else if err = c.MakeRpc("rpc1", &r1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, e := r(ctx)
+	if e != nil {
+		t.Fatal(e)
+	}
+	<-ch
+
+	next, e := it(ctx)
+	if e != nil {
+		t.Fatal(e)
+	}
+	next()
+
+	// the unread streams fill their buffers but don't block other calls
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := r1(1)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("call blocked by unread stream")
+	}
+
+	cancel()
+
+	// the server stops sending
+	for i := 0; i < 2; i++ {
+		select {
+		case <-testStreamStopped:
+		case <-time.After(2 * time.Second):
+			t.Fatal("server stream not stopped")
+		}
+	}
+
+	timeout := time.After(time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-ch:
+			closed = !ok
+		case <-timeout:
+			t.Fatal("stream not closed")
+		}
+	}
+
+	for {
+		if _, ok, err := next(); err != nil {
+			break
+		} else if !ok {
+			t.Fatal("must error")
+		}
+	}
+}
+
+func TestStreamCheck(t *testing.T) {
+	s := newTestServer()
+
+	f := func(a <-chan int, b <-chan int) error { return nil }
+	if err := s.Register("stream_invalid", f); err == nil {
+		t.Fatal("must error")
+	}
+
+	// a streaming result can only be stopped by a context
+	var r func(int) (<-chan int, error)
+	if err := newTestClient().MakeRpc("stream_range", &r); err == nil {
+		t.Fatal("must error")
+	}
+}
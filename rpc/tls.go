@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// Peer is the other side of a connection, a handler can get it by PeerFromContext.
+type Peer struct {
+	Addr net.Addr
+
+	// TLS state, nil if the connection is not encrypted. With client certificate
+	// verification, the verified identity is in TLS.VerifiedChains.
+	TLS *tls.ConnectionState
+
+	// calls to the peer, see MakeRpc
+	out *clientConn
+
+	// closed when the connection is closed
+	done chan struct{}
+}
+
+// CommonName returns the subject common name of the verified peer certificate,
+// or empty if there is none.
+func (p *Peer) CommonName() string {
+	if p.TLS == nil || len(p.TLS.VerifiedChains) == 0 || len(p.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return p.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+type peerKey struct{}
+
+func withPeer(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// newPeer returns the peer of co, the TLS handshake is done here if co is a TLS connection.
+func newPeer(co net.Conn) (*Peer, error) {
+	p := &Peer{Addr: co.RemoteAddr(), done: make(chan struct{})}
+
+	if tc, ok := co.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+		defer cancel()
+
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+
+		state := tc.ConnectionState()
+		p.TLS = &state
+	}
+
+	return p, nil
+}
+
+// SetTLSConfig makes the server accept TLS connections only, set config.ClientAuth
+// to verify client certificates. It must be called before Start.
+func (s *Server) SetTLSConfig(config *tls.Config) {
+	s.tlsConfig = config
+}
+
+// SetTLSConfig makes the client dial TLS connections, only new connections are affected.
+func (c *Client) SetTLSConfig(config *tls.Config) {
+	c.Lock()
+	c.tlsConfig = config
+	c.Unlock()
+}
@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func test_WhoAmI(ctx context.Context) (string, error) {
+	p, ok := PeerFromContext(ctx)
+	if !ok {
+		return "", errors.New("no peer")
+	}
+	return p.CommonName(), nil
+}
+
+func TestTLS(t *testing.T) {
+	ca, caKey, _ := newTestCert(t, "ca", nil, nil)
+	_, _, serverCert := newTestCert(t, "server", ca, caKey)
+	_, _, clientCert := newTestCert(t, "client", ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	s := NewServer("tcp", "127.0.0.1:0")
+	s.SetTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	s.Register("whoami", test_WhoAmI)
+
+	addr := startTestServer(t, s)
+
+	c := NewClient("tcp", addr, 1)
+	defer c.Close()
+
+	c.SetTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      pool,
+	})
+
+	var r func(context.Context) (string, error)
+	if err := c.MakeRpc("whoami", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	var name string
+	var e error
+	for i := 0; i < 10; i++ {
+		if name, e = r(context.Background()); e == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if e != nil {
+		t.Fatal(e)
+	} else if name != "client" {
+		t.Fatal(name)
+	}
+
+	// without client certificate
+	c1 := NewClient("tcp", addr, 1)
+	defer c1.Close()
+
+	c1.SetTLSConfig(&tls.Config{RootCAs: pool})
+	if err := c1.MakeRpc("whoami", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, e = r(context.Background()); e == nil {
+		t.Fatal("must error")
+	}
+
+	// plaintext
+	c2 := NewClient("tcp", addr, 1)
+	defer c2.Close()
+
+	if err := c2.MakeRpc("whoami", &r); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, e = r(ctx); e == nil {
+		t.Fatal("must error")
+	}
+}
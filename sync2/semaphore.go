@@ -1,8 +1,9 @@
 package sync2
 
 import (
+	"container/list"
+	"context"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -10,56 +11,79 @@ func NewSemaphore(initialCount int) *Semaphore {
 	res := &Semaphore{
 		counter: int64(initialCount),
 	}
-	res.cond.L = &res.lock
 	return res
 }
 
 type Semaphore struct {
 	lock    sync.Mutex
-	cond    sync.Cond
 	counter int64
+	// channels of the blocked acquirers in order, a release is handed to the first one,
+	// an acquirer giving up removes itself
+	waiters list.List
 }
 
 func (s *Semaphore) Release() {
 	s.lock.Lock()
 	s.counter += 1
-	if s.counter >= 0 {
-		s.cond.Signal()
+	if s.counter >= 1 {
+		if e := s.waiters.Front(); e != nil {
+			s.waiters.Remove(e)
+			s.counter -= 1
+			close(e.Value.(chan struct{}))
+		}
 	}
 	s.lock.Unlock()
 }
 
 func (s *Semaphore) Acquire() {
+	s.AcquireContext(context.Background())
+}
+
+// TryAcquire acquires the semaphore without blocking, it returns false if none is available.
+func (s *Semaphore) TryAcquire() bool {
 	s.lock.Lock()
-	for s.counter < 1 {
-		s.cond.Wait()
+	defer s.lock.Unlock()
+	if s.counter < 1 {
+		return false
 	}
 	s.counter -= 1
-	s.lock.Unlock()
+	return true
 }
 
-func (s *Semaphore) AcquireTimeout(timeout time.Duration) bool {
-	done := make(chan bool, 1)
-	// Gate used to communicate between the threads and decide what the result
-	// is. If the main thread decides, we have timed out, otherwise we succeed.
-	decided := new(int32)
-	go func() {
-		s.Acquire()
-		if atomic.SwapInt32(decided, 1) == 0 {
-			done <- true
-		} else {
-			// If we already decided the result, and this thread did not win
-			s.Release()
-		}
-	}()
+// AcquireContext acquires the semaphore, it returns false if ctx is done first.
+func (s *Semaphore) AcquireContext(ctx context.Context) bool {
+	s.lock.Lock()
+	if s.counter >= 1 {
+		s.counter -= 1
+		s.lock.Unlock()
+		return true
+	}
+
+	ch := make(chan struct{})
+	e := s.waiters.PushBack(ch)
+	s.lock.Unlock()
+
 	select {
-	case <-done:
+	case <-ch:
 		return true
-	case <-time.NewTimer(timeout).C:
-		if atomic.SwapInt32(decided, 1) == 1 {
-			// The other thread already decided the result
-			return true
-		}
+	case <-ctx.Done():
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	select {
+	case <-ch:
+		// released to us before we gave up
+		return true
+	default:
+		s.waiters.Remove(e)
 		return false
 	}
 }
+
+func (s *Semaphore) AcquireTimeout(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.AcquireContext(ctx)
+}
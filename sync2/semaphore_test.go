@@ -5,6 +5,7 @@
 package sync2
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -39,3 +40,62 @@ func TestSemaTimeout(t *testing.T) {
 		t.Errorf("want true, got false")
 	}
 }
+
+func TestSemaTryAcquire(t *testing.T) {
+	s := NewSemaphore(1)
+	if !s.TryAcquire() {
+		t.Errorf("want true, got false")
+	}
+	if s.TryAcquire() {
+		t.Errorf("want false, got true")
+	}
+	s.Release()
+	if !s.TryAcquire() {
+		t.Errorf("want true, got false")
+	}
+}
+
+func TestSemaContext(t *testing.T) {
+	s := NewSemaphore(1)
+	s.Acquire()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if ok := s.AcquireContext(ctx); ok {
+		t.Errorf("want false, got true")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Release()
+	}()
+	if ok := s.AcquireContext(context.Background()); !ok {
+		t.Errorf("want true, got false")
+	}
+}
+
+func TestSemaContextNoLeak(t *testing.T) {
+	s := NewSemaphore(1)
+	s.Acquire()
+
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if ok := s.AcquireContext(ctx); ok {
+			t.Errorf("want false, got true")
+		}
+	}
+
+	// the canceled acquirers don't wait any more
+	s.lock.Lock()
+	n := s.waiters.Len()
+	s.lock.Unlock()
+	if n != 0 {
+		t.Errorf("want 0 waiters, got %d", n)
+	}
+
+	s.Release()
+	if !s.TryAcquire() {
+		t.Errorf("want true, got false")
+	}
+}